@@ -0,0 +1,73 @@
+package params
+
+import (
+	"data-explorer/pkg/dataexplorer/template"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/cobra"
+)
+
+var ParamsCmd = &cobra.Command{
+	Use:   "params [file]",
+	Short: "List the params used by a query, read from file or stdin",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		input := io.Reader(os.Stdin)
+		if len(args) == 1 {
+			file, err := os.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			input = file
+		}
+
+		query, err := io.ReadAll(input)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if !template.IsEngine(engine) {
+			log.Fatalf("unknown template engine %s", engine)
+		}
+
+		params, err := template.DiscoverParams(string(query), engine, nil, snippetBody)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		output, err := jsoniter.MarshalIndent(params, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(output))
+	},
+}
+
+var (
+	engine      string
+	snippetsDir string
+)
+
+// snippetBody reads the snippet included as ${include:name} from <snippets>/<name>.sql.
+func snippetBody(name string) (string, error) {
+	if snippetsDir == "" {
+		return "", errors.New("pass --snippets to expand includes")
+	}
+	body, err := os.ReadFile(filepath.Join(snippetsDir, name+".sql"))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func init() {
+	ParamsCmd.Flags().StringVar(&engine, "engine", template.EngineSimple, "Template engine of the query, simple or go")
+	ParamsCmd.Flags().StringVar(&snippetsDir, "snippets", "", "Directory of the snippets included by the query, one <name>.sql file each")
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"data-explorer/cmd/params"
 	"data-explorer/cmd/serve"
 	"os"
 
@@ -24,4 +25,5 @@ func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	rootCmd.AddCommand(serve.ServeCmd)
+	rootCmd.AddCommand(params.ParamsCmd)
 }
@@ -1,11 +1,13 @@
 package serve
 
 import (
+	"context"
 	"data-explorer/pkg/dataexplorer/conf"
 	"data-explorer/pkg/dataexplorer/server"
 	"errors"
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
@@ -27,11 +29,16 @@ var ServeCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		server, err := server.NewServer(connectionsConf)
+		server, err := server.NewServer(connectionsConf, serverOptions)
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		go server.WatchConnections(context.Background(), connectionsPath, reloadInterval)
+		if healthInterval > 0 {
+			go server.MonitorConnections(context.Background(), healthInterval)
+		}
+
 		if err := server.Run(); err != nil {
 			log.Fatal(err)
 		}
@@ -39,8 +46,18 @@ var ServeCmd = &cobra.Command{
 }
 
 var connectionsPath string
+var reloadInterval time.Duration
+var healthInterval time.Duration
+var serverOptions server.Options
 
 func init() {
 	ServeCmd.Flags().StringVarP(&connectionsPath, "connections", "c", "connections.yaml", "Path to the connection conf file")
+	ServeCmd.Flags().DurationVar(&reloadInterval, "reload-interval", 2*time.Second, "How often to check the connection conf file for changes, 0 to reload on SIGHUP only")
+	ServeCmd.Flags().DurationVar(&healthInterval, "health-interval", time.Minute, "How often to check the health of every connection, 0 to disable")
+	ServeCmd.Flags().IntVar(&serverOptions.JobWorkers, "job-workers", 4, "Number of asynchronous queries executed at the same time")
+	ServeCmd.Flags().IntVar(&serverOptions.JobQueueSize, "job-queue-size", 100, "Number of asynchronous queries that can wait for a worker")
+	ServeCmd.Flags().IntVar(&serverOptions.MaxConcurrentQueries, "max-concurrent-queries", 20, "Number of queries running at the same time across all connections, 0 for unlimited")
+	ServeCmd.Flags().IntVar(&serverOptions.MaxConcurrentQueriesPerConnection, "max-concurrent-queries-per-connection", 5, "Number of queries running at the same time on a connection without max_concurrent_queries, 0 for unlimited")
+	ServeCmd.Flags().IntVar(&serverOptions.MaxQueuedQueries, "max-queued-queries", 100, "Number of queries waiting to run before new ones are rejected, 0 for unlimited")
 	_ = ServeCmd.MarkFlagRequired("connections")
 }
@@ -1,5 +1,7 @@
 package conf
 
+import "time"
+
 type ConnectionsConfiguration struct {
 	Connections []Connection `yaml:"connections"`
 }
@@ -7,8 +9,35 @@ type ConnectionsConfiguration struct {
 type Connection struct {
 	Id  string `yaml:"id"`
 	DSN string `yaml:"dsn"`
+
+	// Name and Tags are only used for display.
+	Name string   `yaml:"name"`
+	Tags []string `yaml:"tags"`
+
+	// Pool settings, zero values fall back to the defaults of the connection package.
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+
+	// QueryTimeout bounds every query sent to this connection, e.g. "30s" or "10m".
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// MaxRows stops reading results after this many rows, 0 means unlimited.
+	MaxRows int `yaml:"max_rows"`
+	// MaxConcurrentQueries caps the queries running at once on this connection,
+	// 0 uses the server default.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+	// ReadOnly runs queries inside read-only transactions where the driver supports it,
+	// and refuses statements other than connection.ReadStatements.
+	ReadOnly bool `yaml:"read_only"`
+	// ConfirmInputBytes makes queries estimated to read more than this many bytes
+	// fail unless they are confirmed, for drivers that estimate costs. 0 disables it.
+	ConfirmInputBytes int64 `yaml:"confirm_input_bytes"`
+	// AllowedStatements lists the statement types queries may run, such as select or
+	// insert. When set it replaces the statements ReadOnly allows.
+	AllowedStatements []string `yaml:"allowed_statements"`
 }
 
 func LoadConnection(path string) (*ConnectionsConfiguration, error) {
-	return LoadFromYAML[ConnectionsConfiguration](path)
+	return LoadFromYAMLWithInterpolation[ConnectionsConfiguration](path)
 }
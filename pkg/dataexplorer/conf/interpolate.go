@@ -0,0 +1,125 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reference matches ${ENV_VAR} and ${file:/path/to/secret}, or the $${ escape.
+var reference = regexp.MustCompile(`\$\$\{|\$\{([^}]+)\}`)
+
+const (
+	filePrefix = "file:"
+	escape     = "$${"
+)
+
+// Interpolate replaces ${ENV_VAR} with the value of the environment variable and
+// ${file:/path} with the content of the file, without its trailing newline. $${ is
+// a literal ${.
+func Interpolate(value string) (string, error) {
+	var err error
+	expanded := reference.ReplaceAllStringFunc(value, func(s string) string {
+		if err != nil {
+			return s
+		}
+		if s == escape {
+			return s[1:]
+		}
+		name := strings.TrimSpace(s[2 : len(s)-1])
+
+		if path, ok := strings.CutPrefix(name, filePrefix); ok {
+			bytes, readErr := os.ReadFile(path)
+			if readErr != nil {
+				err = fmt.Errorf("failed to read secret file %s: %w", path, readErr)
+				return s
+			}
+			return strings.TrimRight(string(bytes), "\r\n")
+		}
+
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			err = fmt.Errorf("environment variable %s is not set", name)
+			return s
+		}
+		return env
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// LoadFromYAMLWithInterpolation works like LoadFromYAML but interpolates every
+// scalar value first, so references can be used in any field.
+func LoadFromYAMLWithInterpolation[T any](path string) (*T, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(bytes, &node); err != nil {
+		return nil, err
+	}
+
+	if err := interpolateNode(&node, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var t T
+	if err := node.Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func interpolateNode(node *yaml.Node, fieldPath string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, fieldPath); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for idx, child := range node.Content {
+			if err := interpolateNode(child, fieldPath+"["+strconv.Itoa(idx)+"]"); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			childPath := node.Content[idx].Value
+			if fieldPath != "" {
+				childPath = fieldPath + "." + childPath
+			}
+			if err := interpolateNode(node.Content[idx+1], childPath); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		value, err := Interpolate(node.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fieldPath, err)
+		}
+		node.Value = value
+		// Quoted and !!str values stay strings, the decoder resolves the type of plain ones
+		// again so that port: ${PORT} is a number.
+		quoted := node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) != 0
+		if quoted || node.Style&yaml.TaggedStyle != 0 && node.Tag == "!!str" {
+			node.Tag = "!!str"
+			return nil
+		}
+		node.Tag = ""
+		node.Style = 0
+	}
+	return nil
+}
@@ -1,59 +1,298 @@
 package connection
 
 import (
+	"context"
 	"data-explorer/pkg/dataexplorer/conf"
+	"database/sql"
 	"fmt"
+	"log"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+const (
+	DefaultMaxOpenConns = 10
+	DefaultMaxIdleConns = 2
+	// ConnectTimeout bounds how long opening a connection waits for the database.
+	ConnectTimeout = 10 * time.Second
+)
+
 type Connection struct {
-	Id string
-	DB *sqlx.DB
+	Id            string
+	Driver        *Driver
+	DB            *sqlx.DB
+	Configuration conf.Connection
+
+	// users counts who acquired the connection, the pool of a retired connection is
+	// closed once they are all done.
+	mu      sync.Mutex
+	users   int
+	retired bool
 }
 
-func NewConnection(id string, db *sqlx.DB) *Connection {
+func NewConnection(configuration conf.Connection, driver *Driver, db *sqlx.DB) *Connection {
 	return &Connection{
-		Id: id,
-		DB: db,
+		Id:            configuration.Id,
+		Driver:        driver,
+		DB:            db,
+		Configuration: configuration,
+	}
+}
+
+// Query runs the query with the timeout, row limit and read-only settings of the connection.
+func (connection *Connection) Query(ctx context.Context, query string, options QueryOptions) (*QueryResult, error) {
+	queryResult := QueryResult{}
+	summary, err := connection.Stream(ctx, query, options, &queryResult)
+	if err != nil {
+		return nil, err
+	}
+	queryResult.ResultSummary = *summary
+	return &queryResult, nil
+}
+
+// Stream works like Query but hands rows to handler as they are scanned.
+func (connection *Connection) Stream(ctx context.Context, query string, options QueryOptions, handler RowHandler) (*ResultSummary, error) {
+	configuration := connection.Configuration
+	maxRows := EffectiveLimit(configuration.MaxRows, options.MaxRows)
+
+	if configuration.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, configuration.QueryTimeout)
+		defer cancel()
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// Statements run on a dedicated connection so that they can be killed by session.
+	conn, err := connection.DB.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if connection.Driver.Killer != nil && options.Kill {
+		statement, preparedQuery, err := newRunningStatement(ctx, connection, conn, query)
+		if err != nil {
+			return nil, err
+		}
+		defer statement.finish()
+		query = preparedQuery
+	}
+
+	if !configuration.ReadOnly || !connection.Driver.Capabilities.ReadOnlyTransactions {
+		return Stream(ctx, conn, query, maxRows, handler, options.Progress, stop, options.Args...)
+	}
+
+	tx, err := conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	return Stream(ctx, tx, query, maxRows, handler, options.Progress, stop, options.Args...)
+}
+
+func (connection *Connection) acquire() bool {
+	connection.mu.Lock()
+	defer connection.mu.Unlock()
+
+	if connection.retired {
+		return false
 	}
+	connection.users++
+	return true
+}
+
+func (connection *Connection) release() {
+	connection.mu.Lock()
+	defer connection.mu.Unlock()
+
+	connection.users--
+	if connection.retired && connection.users == 0 {
+		connection.close()
+	}
+}
+
+// retire closes the pool once the connection is released by everyone who acquired it.
+func (connection *Connection) retire() {
+	connection.mu.Lock()
+	defer connection.mu.Unlock()
+
+	connection.retired = true
+	if connection.users == 0 {
+		connection.close()
+	}
+}
+
+func (connection *Connection) close() {
+	go func() {
+		if err := connection.DB.Close(); err != nil {
+			log.Printf("failed to close connection %s: %v", connection.Id, err)
+		}
+	}()
 }
 
 type ConnectionHolder struct {
 	mu            sync.Mutex
 	Connections   []*Connection
 	Configuration []conf.Connection
+	health        map[string]Health
 }
 
 func NewConnectionHolder(configuration []conf.Connection) *ConnectionHolder {
 	return &ConnectionHolder{
 		Configuration: configuration,
+		health:        map[string]Health{},
 	}
 }
 
-func (holder *ConnectionHolder) GetDB(id string) (*sqlx.DB, error) {
+// AcquireConnection returns the connection, opening it first if needed, and keeps its
+// pool open until release is called, even when a reload replaces the connection meanwhile.
+func (holder *ConnectionHolder) AcquireConnection(id string) (*Connection, func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ConnectTimeout)
+	defer cancel()
+	return holder.acquireConnection(ctx, id)
+}
+
+func (holder *ConnectionHolder) acquireConnection(ctx context.Context, id string) (*Connection, func(), error) {
+	for {
+		connection, err := holder.getConnection(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		// A connection retired in between is gone from the holder, the next one is new.
+		if connection.acquire() {
+			return connection, connection.release, nil
+		}
+	}
+}
+
+// getConnection opens the connection without holding the lock, so that a slow or
+// unreachable database does not hold up the other connections.
+func (holder *ConnectionHolder) getConnection(ctx context.Context, id string) (*Connection, error) {
+	holder.mu.Lock()
+	connection, configuration, ok := holder.lookup(id)
+	holder.mu.Unlock()
+	if connection != nil {
+		return connection, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("connection id is invalid: %s", id)
+	}
+
+	driver, err := LookupDriver(configuration.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db, err := driver.Open(ctx, configuration.DSN)
+	if err != nil {
+		return nil, err
+	}
+	applyPoolSettings(db, configuration)
+
+	holder.mu.Lock()
+	connection, current, ok := holder.lookup(id)
+	if connection == nil && ok && reflect.DeepEqual(current, configuration) {
+		connection = NewConnection(configuration, driver, db)
+		holder.Connections = append(holder.Connections, connection)
+		holder.mu.Unlock()
+		return connection, nil
+	}
+	holder.mu.Unlock()
+
+	// Another caller opened the connection meanwhile or it was reloaded, the pool is not needed.
+	if err := db.Close(); err != nil {
+		log.Printf("failed to close connection %s: %v", id, err)
+	}
+	return holder.getConnection(ctx, id)
+}
+
+// lookup finds the open connection with id, or else its configuration. The lock must be held.
+func (holder *ConnectionHolder) lookup(id string) (*Connection, conf.Connection, bool) {
+	for _, connection := range holder.Connections {
+		if connection.Id == id {
+			return connection, connection.Configuration, true
+		}
+	}
+	for _, configuration := range holder.Configuration {
+		if configuration.Id == id {
+			return nil, configuration, true
+		}
+	}
+	return nil, conf.Connection{}, false
+}
+
+// GetDriver returns the driver of a connection without connecting to it.
+func (holder *ConnectionHolder) GetDriver(id string) (*Driver, error) {
+	for _, configuration := range holder.ConnectionConfigurations() {
+		if configuration.Id == id {
+			return LookupDriver(configuration.DSN)
+		}
+	}
+	return nil, fmt.Errorf("connection id is invalid: %s", id)
+}
+
+// Reload replaces the configuration. Pools of removed or changed connections are
+// closed in the background once the queries that acquired them are done.
+func (holder *ConnectionHolder) Reload(configuration []conf.Connection) {
 	holder.mu.Lock()
 	defer holder.mu.Unlock()
 
-	for idx := range holder.Connections {
-		if holder.Connections[idx].Id == id {
-			return holder.Connections[idx].DB, nil
+	next := map[string]conf.Connection{}
+	for _, connectionConf := range configuration {
+		next[connectionConf.Id] = connectionConf
+	}
+
+	for id := range holder.health {
+		if _, ok := next[id]; !ok {
+			delete(holder.health, id)
 		}
 	}
 
-	for idx := range holder.Configuration {
-		conf := holder.Configuration[idx]
-		if conf.Id == id {
-			db, err := NewDB(conf.DSN)
-			if err != nil {
-				return nil, err
-			}
-			connection := NewConnection(id, db)
-			holder.Connections = append(holder.Connections, connection)
-			return db, nil
+	var kept []*Connection
+	for _, connection := range holder.Connections {
+		if connectionConf, ok := next[connection.Id]; ok && reflect.DeepEqual(connectionConf, connection.Configuration) {
+			kept = append(kept, connection)
+			continue
 		}
+		log.Printf("closing connection %s after configuration change", connection.Id)
+		connection.retire()
 	}
 
-	return nil, fmt.Errorf("connection id is invalid: %s", id)
+	holder.Connections = kept
+	holder.Configuration = configuration
+}
+
+// ConnectionConfigurations returns a copy of the current configuration.
+func (holder *ConnectionHolder) ConnectionConfigurations() []conf.Connection {
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	return append([]conf.Connection(nil), holder.Configuration...)
+}
+
+func applyPoolSettings(db *sqlx.DB, conf conf.Connection) {
+	maxOpenConns := conf.MaxOpenConns
+	if maxOpenConns == 0 {
+		maxOpenConns = DefaultMaxOpenConns
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+
+	maxIdleConns := conf.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = DefaultMaxIdleConns
+	}
+	db.SetMaxIdleConns(maxIdleConns)
+
+	if conf.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	}
+	if conf.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(conf.ConnMaxIdleTime)
+	}
 }
@@ -0,0 +1,38 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+)
+
+// Cost is what the database estimates a query would use, before it runs.
+type Cost struct {
+	// InputBytes is the amount of data the query would read.
+	InputBytes int64 `json:"input_bytes"`
+	// UDF is the number of user defined functions the query calls.
+	UDF int `json:"udf"`
+	// Complexity weighs the input by the work done on it, MaxCompute bills input times complexity.
+	Complexity float64 `json:"complexity"`
+}
+
+// Add accumulates the cost of another statement, the complexity is the highest of both.
+func (cost *Cost) Add(other *Cost) {
+	cost.InputBytes += other.InputBytes
+	cost.UDF += other.UDF
+	cost.Complexity = max(cost.Complexity, other.Complexity)
+}
+
+// EstimateCost asks the database what query would cost without running it.
+func (connection *Connection) EstimateCost(ctx context.Context, query string) (*Cost, error) {
+	if connection.Driver.EstimateCost == nil {
+		return nil, fmt.Errorf("connection %s does not support cost estimation", connection.Id)
+	}
+
+	if timeout := connection.Configuration.QueryTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return connection.Driver.EstimateCost(ctx, connection, query)
+}
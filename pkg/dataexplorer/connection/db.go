@@ -3,35 +3,109 @@ package connection
 import (
 	"context"
 	"database/sql"
-	"errors"
-	"strings"
 
-	_ "github.com/aliyun/aliyun-odps-go-sdk/sqldriver"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
 	"github.com/samber/lo"
 )
 
 func NewDB(dsn string) (*sqlx.DB, error) {
-	if strings.HasPrefix(dsn, "postgres") {
-		return sqlx.Connect("postgres", strings.TrimPrefix(dsn, "postgres://"))
-	} else if strings.HasPrefix(dsn, "mysql") {
-		return sqlx.Connect("mysql", strings.TrimPrefix(dsn, "mysql://"))
-	} else if strings.Contains(dsn, "maxcompute.aliyun.com/api") {
-		return sqlx.Connect("odps", dsn)
+	driver, err := LookupDriver(dsn)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("Invalid DSN")
+	ctx, cancel := context.WithTimeout(context.Background(), ConnectTimeout)
+	defer cancel()
+	return driver.Open(ctx, dsn)
 }
 
 type QueryResult struct {
 	ColumnNames []string      `json:"column_names"`
 	ColumnTypes []string      `json:"column_types"`
 	Records     []interface{} `json:"records"`
+	ResultSummary
+}
+
+// ResultSummary tells how many rows were read and whether the row limit cut the result off.
+type ResultSummary struct {
+	RowCount  int  `json:"row_count"`
+	Truncated bool `json:"truncated"`
+	// Limit is the row limit that was applied, 0 when unlimited.
+	Limit int `json:"limit"`
+}
+
+type QueryOptions struct {
+	// MaxRows is the row limit requested by the caller, the stricter of it and
+	// the limit of the connection wins. 0 means no limit.
+	MaxRows int
+	// Kill stops the statement on the server once its context is done, for drivers
+	// with a Killer. It costs a round trip before the statement runs.
+	Kill bool
+	// Progress is called with the number of rows read so far after every row.
+	Progress func(rowCount int)
+	// Queued is called with the place of the query in the scheduler queue, 0 once it runs.
+	Queued func(position int)
+	// Args are bound to the placeholders of the query, see Driver.Placeholder.
+	Args []interface{}
+	// ConfirmCost runs the query even when its estimated cost is above the
+	// confirm_input_bytes of the connection.
+	ConfirmCost bool
+}
+
+// EffectiveLimit returns the stricter of two row limits where 0 means unlimited.
+func EffectiveLimit(limit int, other int) int {
+	if limit <= 0 {
+		return max(other, 0)
+	}
+	if other <= 0 {
+		return limit
+	}
+	return min(limit, other)
+}
+
+// RowHandler receives the columns of a result once, followed by each row as it is scanned.
+type RowHandler interface {
+	Columns(columnNames []string, columnTypes []string) error
+	Row(record []interface{}) error
+}
+
+func (queryResult *QueryResult) Columns(columnNames []string, columnTypes []string) error {
+	queryResult.ColumnNames = columnNames
+	queryResult.ColumnTypes = columnTypes
+	return nil
+}
+
+func (queryResult *QueryResult) Row(record []interface{}) error {
+	queryResult.Records = append(queryResult.Records, record)
+	return nil
+}
+
+// Query runs the query and collects the result, stopping after maxRows rows when maxRows > 0.
+func Query(ctx context.Context, queryer sqlx.QueryerContext, query string, maxRows int, args ...interface{}) (*QueryResult, error) {
+	queryResult := QueryResult{}
+	summary, err := Stream(ctx, queryer, query, maxRows, &queryResult, nil, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	queryResult.ResultSummary = *summary
+	return &queryResult, nil
 }
 
-func Query(ctx context.Context, db *sqlx.DB, query string) (*QueryResult, error) {
-	rows, err := db.QueryxContext(ctx, query)
+// Stream runs the query and hands every row to handler without keeping it in memory,
+// stopping after maxRows rows when maxRows > 0. progress is optional. Values are
+// normalized after the type of their column, see normalizeValue. stop, when not nil,
+// cancels the context of the query once maxRows is reached, as closing the rows would
+// read the rest of the result otherwise. The connection cannot be used afterwards.
+func Stream(
+	ctx context.Context,
+	queryer sqlx.QueryerContext,
+	query string,
+	maxRows int,
+	handler RowHandler,
+	progress func(rowCount int),
+	stop context.CancelFunc,
+	args ...interface{},
+) (*ResultSummary, error) {
+	rows, err := queryer.QueryxContext(ctx, query, args...)
 
 	if err != nil {
 		return nil, err
@@ -39,30 +113,55 @@ func Query(ctx context.Context, db *sqlx.DB, query string) (*QueryResult, error)
 
 	defer rows.Close()
 
-	queryResult := QueryResult{}
-
 	columns, err := rows.Columns()
 	if err != nil {
 		return nil, err
 	}
-	queryResult.ColumnNames = columns
 
 	columnTypes, err := rows.ColumnTypes()
 	if err != nil {
 		return nil, err
 	}
 
-	queryResult.ColumnTypes = lo.Map(columnTypes, func(item *sql.ColumnType, index int) string {
+	if err := handler.Columns(columns, lo.Map(columnTypes, func(item *sql.ColumnType, index int) string {
 		return item.DatabaseTypeName()
+	})); err != nil {
+		return nil, err
+	}
+	kinds := lo.Map(columnTypes, func(item *sql.ColumnType, index int) columnKind {
+		return columnKindOf(item)
 	})
 
+	summary := ResultSummary{
+		Limit: max(maxRows, 0),
+	}
 	for rows.Next() {
-		if record, err := rows.SliceScan(); err != nil {
+		if maxRows > 0 && summary.RowCount >= maxRows {
+			summary.Truncated = true
+			if stop != nil {
+				stop()
+				return &summary, nil
+			}
+			break
+		}
+		record, err := rows.SliceScan()
+		if err != nil {
+			return nil, err
+		}
+		for index := range record {
+			record[index] = normalizeValue(record[index], kinds[index])
+		}
+		if err := handler.Row(record); err != nil {
 			return nil, err
-		} else {
-			queryResult.Records = append(queryResult.Records, record)
+		}
+		summary.RowCount++
+		if progress != nil {
+			progress(summary.RowCount)
 		}
 	}
 
-	return &queryResult, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &summary, nil
 }
@@ -0,0 +1,34 @@
+package connection
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/samber/lo"
+)
+
+func init() {
+	RegisterDriver(&Driver{
+		Name:    "duckdb",
+		Schemes: []string{"duckdb"},
+		TranslateDSN: func(dsn string) (string, error) {
+			if !lo.Contains(sql.Drivers(), "duckdb") {
+				return "", errors.New("duckdb support is not compiled in, rebuild with -tags duckdb")
+			}
+			return trimScheme(dsn), nil
+		},
+		VersionQuery: "SELECT version()",
+		Placeholder:  questionPlaceholder,
+		Dialect:      Dialect{DollarQuotes: true, EscapeStrings: true},
+		// DuckDB renders its plan as a drawing, only the raw output is returned.
+		Explainer: &Explainer{
+			Statement: func(query string) string {
+				return "EXPLAIN " + query
+			},
+		},
+		Capabilities: Capabilities{
+			Explain: true,
+			Schemas: true,
+		},
+	})
+}
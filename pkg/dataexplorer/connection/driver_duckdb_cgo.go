@@ -0,0 +1,6 @@
+//go:build duckdb
+
+package connection
+
+// DuckDB needs cgo, so the driver is only linked when building with -tags duckdb.
+import _ "github.com/marcboeker/go-duckdb"
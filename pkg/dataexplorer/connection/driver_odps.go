@@ -0,0 +1,204 @@
+package connection
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aliyun/aliyun-odps-go-sdk/odps"
+	"github.com/aliyun/aliyun-odps-go-sdk/odps/common"
+	"github.com/aliyun/aliyun-odps-go-sdk/sqldriver"
+	"github.com/jmoiron/sqlx"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const odpsMarkerPrefix = "-- data-explorer:"
+
+func init() {
+	// MaxCompute DSNs are plain http(s) endpoints, so they are recognised by host.
+	RegisterDriver(&Driver{
+		Name: "odps",
+		Match: func(dsn string) bool {
+			return strings.Contains(dsn, "maxcompute.aliyun.com/api")
+		},
+		Killer: &Killer{
+			Prepare: prepareODPSQuery,
+			Kill:    terminateODPSInstance,
+		},
+		Dialect: Dialect{BackslashEscapes: true},
+		Explainer: &Explainer{
+			Run:  odpsCost,
+			Tree: odpsCostTree,
+		},
+		EstimateCost: estimateODPSCost,
+		Capabilities: Capabilities{
+			Cancel:  true,
+			Explain: true,
+			Cost:    true,
+		},
+	})
+}
+
+// prepareODPSQuery tags the query with a comment, since the sql driver does not
+// expose the instance it creates, the tag is how terminateODPSInstance finds it.
+func prepareODPSQuery(ctx context.Context, conn *sqlx.Conn, query string) (string, string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	marker := odpsMarkerPrefix + hex.EncodeToString(bytes)
+	return marker + "\n" + query, marker, nil
+}
+
+// terminateODPSInstance stops the running instances of the account whose SQL carries the marker.
+func terminateODPSInstance(ctx context.Context, connection *Connection, marker string) error {
+	config, err := sqldriver.ParseDSN(connection.Configuration.DSN)
+	if err != nil {
+		return err
+	}
+	odpsIns := config.GenOdps()
+
+	var instances []*odps.Instance
+	var errs []error
+	odpsIns.Instances().List(func(instance *odps.Instance, err error) {
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		source, err := odpsInstanceSource(odpsIns, instance)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if strings.Contains(source, marker) {
+			instances = append(instances, instance)
+		}
+	}, odps.InstanceFilter.Status(odps.InstanceRunning), odps.InstanceFilter.OnlyOwner())
+
+	for _, instance := range instances {
+		if err := instance.Terminate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(instances) == 0 && len(errs) == 0 {
+		return errors.New("no running MaxCompute instance found for the query")
+	}
+	return errors.Join(errs...)
+}
+
+// odpsInstanceSource returns the job definition of the instance, which contains its SQL.
+func odpsInstanceSource(odpsIns *odps.Odps, instance *odps.Instance) (string, error) {
+	rb := common.ResourceBuilder{ProjectName: instance.ProjectName()}
+	client := odpsIns.RestClient()
+
+	var source string
+	err := client.GetWithParseFunc(rb.Instance(instance.Id()), url.Values{"source": []string{""}}, func(res *http.Response) error {
+		bytes, err := io.ReadAll(res.Body)
+		source = string(bytes)
+		return err
+	})
+	return source, err
+}
+
+// odpsCost explains a query with its cost estimate, see odpsCostSummary.
+func odpsCost(ctx context.Context, connection *Connection, query string) (string, interface{}, error) {
+	content, err := odpsCostSummary(ctx, connection, query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var raw interface{}
+	if err := jsoniter.UnmarshalFromString(content, &raw); err != nil {
+		raw = content
+	}
+	return "COST SQL " + query, raw, nil
+}
+
+// estimateODPSCost reads the input size, UDF count and complexity out of the
+// {"Cost": {"SQLSummary": ...}} estimate of the query.
+func estimateODPSCost(ctx context.Context, connection *Connection, query string) (*Cost, error) {
+	content, err := odpsCostSummary(ctx, connection, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Cost struct {
+			SQLSummary map[string]interface{}
+		}
+	}
+	if err := jsoniter.UnmarshalFromString(content, &document); err != nil {
+		return nil, fmt.Errorf("cannot read the MaxCompute cost estimate: %w", err)
+	}
+	summary := document.Cost.SQLSummary
+	if summary == nil {
+		return nil, fmt.Errorf("cannot read the MaxCompute cost estimate: %s", content)
+	}
+
+	var cost Cost
+	var input, udf float64
+	for key, target := range map[string]*float64{"Input": &input, "UDF": &udf, "Complexity": &cost.Complexity} {
+		value, ok := summary[key]
+		if !ok {
+			continue
+		}
+		// Numbers may come as strings.
+		if *target, err = strconv.ParseFloat(fmt.Sprint(value), 64); err != nil {
+			return nil, fmt.Errorf("invalid %s in the MaxCompute cost estimate: %v", key, value)
+		}
+	}
+	cost.InputBytes = int64(input)
+	cost.UDF = int(udf)
+	return &cost, nil
+}
+
+// odpsCostSummary runs query as a SQLCost task, which estimates what the query would
+// read without running it, like COST SQL in the MaxCompute console. It returns the
+// content of the task result.
+func odpsCostSummary(ctx context.Context, connection *Connection, query string) (string, error) {
+	config, err := sqldriver.ParseDSN(connection.Configuration.DSN)
+	if err != nil {
+		return "", err
+	}
+	odpsIns := config.GenOdps()
+
+	task := odps.NewSQLCostTask("AnonymousSQLCostTask", query, "", nil)
+	instance, err := odpsIns.Instances().CreateTask(odpsIns.DefaultProjectName(), &task)
+	if err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- instance.WaitForSuccess()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		_ = instance.Terminate()
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	results, err := instance.GetResult()
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "", fmt.Errorf("no result for MaxCompute instance %s", instance.Id())
+	}
+	return results[0].Content(), nil
+}
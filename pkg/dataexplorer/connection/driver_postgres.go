@@ -0,0 +1,54 @@
+package connection
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// keywordDSN matches the libpq "key=value ..." connection string form.
+var keywordDSN = regexp.MustCompile(`^\s*\w+\s*=`)
+
+func init() {
+	RegisterDriver(&Driver{
+		Name:    "postgres",
+		Schemes: []string{"postgres", "postgresql"},
+		TranslateDSN: func(dsn string) (string, error) {
+			// postgres://host=... user=... has always been accepted, so keep
+			// passing the keyword form through without the scheme. Real URLs are
+			// understood by lib/pq as they are.
+			if rest := trimScheme(dsn); keywordDSN.MatchString(rest) {
+				return rest, nil
+			}
+			return dsn, nil
+		},
+		VersionQuery: "SELECT version()",
+		Killer: &Killer{
+			Prepare: func(ctx context.Context, conn *sqlx.Conn, query string) (string, string, error) {
+				var pid string
+				err := conn.GetContext(ctx, &pid, "SELECT pg_backend_pid()")
+				return query, pid, err
+			},
+			Kill: func(ctx context.Context, connection *Connection, pid string) error {
+				_, err := connection.DB.ExecContext(ctx, "SELECT pg_cancel_backend($1)", pid)
+				return err
+			},
+		},
+		Placeholder: dollarPlaceholder,
+		Dialect:     Dialect{DollarQuotes: true, EscapeStrings: true},
+		Explainer: &Explainer{
+			Statement: func(query string) string {
+				return "EXPLAIN (FORMAT JSON) " + query
+			},
+			Tree: postgresPlanTree,
+		},
+		Capabilities: Capabilities{
+			Cancel:               true,
+			Explain:              true,
+			ReadOnlyTransactions: true,
+			Schemas:              true,
+		},
+	})
+}
@@ -0,0 +1,28 @@
+package connection
+
+import (
+	_ "github.com/glebarez/go-sqlite"
+)
+
+func init() {
+	RegisterDriver(&Driver{
+		Name:    "sqlite",
+		Schemes: []string{"sqlite"},
+		TranslateDSN: func(dsn string) (string, error) {
+			return trimScheme(dsn), nil
+		},
+		VersionQuery: "SELECT sqlite_version()",
+		Placeholder:  questionPlaceholder,
+		Explainer: &Explainer{
+			Statement: func(query string) string {
+				return "EXPLAIN QUERY PLAN " + query
+			},
+			Tree: sqlitePlanTree,
+		},
+		// Cancelling the context stops sqlite between rows only, a long running
+		// aggregate cannot be interrupted.
+		Capabilities: Capabilities{
+			Explain: true,
+		},
+	})
+}
@@ -0,0 +1,325 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/samber/lo"
+)
+
+// Explainer gets the plan of a query from a database without running it.
+type Explainer struct {
+	// Statement wraps a query into the statement that returns its plan.
+	Statement func(query string) string
+	// Run gets the raw plan when the database does not return it as rows, it replaces Statement.
+	Run func(ctx context.Context, connection *Connection, query string) (string, interface{}, error)
+	// Tree normalizes the raw plan, nil when the driver does not know its format.
+	Tree func(raw interface{}) ([]*PlanNode, error)
+}
+
+// Plan is how the database would run a query.
+type Plan struct {
+	// Statement is what was sent to the database to get the plan.
+	Statement string `json:"statement"`
+	// Raw is the plan as the database returned it. Plans returned as a single JSON
+	// value are decoded, the others are kept as a *QueryResult.
+	Raw interface{} `json:"raw"`
+	// Tree is the plan normalized across databases, empty when it cannot be parsed.
+	Tree []*PlanNode `json:"tree"`
+}
+
+// PlanNode is an operation of a plan, Children feed it with rows.
+type PlanNode struct {
+	Operation string `json:"operation"`
+	// Detail is what the operation works on, usually a table.
+	Detail string `json:"detail,omitempty"`
+	// Properties are the other attributes reported by the database, as they are.
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	Children   []*PlanNode            `json:"children,omitempty"`
+}
+
+// Explain returns the plan of query. Only the Args and Kill options apply, as the
+// explained statement may run with EXPLAIN ANALYZE it can be killed like a query.
+func (connection *Connection) Explain(ctx context.Context, query string, options QueryOptions) (*Plan, error) {
+	explainer := connection.Driver.Explainer
+	if explainer == nil {
+		return nil, fmt.Errorf("connection %s does not support explain", connection.Id)
+	}
+
+	if timeout := connection.Configuration.QueryTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	plan := Plan{}
+	if explainer.Run != nil {
+		if len(options.Args) > 0 {
+			return nil, fmt.Errorf("connection %s does not support bind params", connection.Id)
+		}
+		if err := connection.CheckStatements([]string{query}); err != nil {
+			return nil, err
+		}
+		var err error
+		if plan.Statement, plan.Raw, err = explainer.Run(ctx, connection, query); err != nil {
+			return nil, err
+		}
+	} else {
+		// The policy applies to what runs, EXPLAIN ANALYZE runs the explained statement.
+		plan.Statement = explainer.Statement(query)
+		if err := connection.CheckStatements([]string{plan.Statement}); err != nil {
+			return nil, err
+		}
+
+		conn, err := connection.DB.Connx(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		statement := plan.Statement
+		if connection.Driver.Killer != nil && options.Kill {
+			running, preparedStatement, err := newRunningStatement(ctx, connection, conn, statement)
+			if err != nil {
+				return nil, err
+			}
+			defer running.finish()
+			statement = preparedStatement
+		}
+
+		var queryer sqlx.QueryerContext = conn
+		if connection.Configuration.ReadOnly && connection.Driver.Capabilities.ReadOnlyTransactions {
+			tx, err := conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				_ = tx.Rollback()
+			}()
+			queryer = tx
+		}
+
+		result, err := Query(ctx, queryer, statement, 0, options.Args...)
+		if err != nil {
+			return nil, err
+		}
+		plan.Raw = rawPlan(result)
+	}
+
+	if explainer.Tree != nil {
+		tree, err := explainer.Tree(plan.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the plan: %w", err)
+		}
+		plan.Tree = tree
+	}
+	return &plan, nil
+}
+
+// rawPlan decodes results made of a single JSON document.
+func rawPlan(result *QueryResult) interface{} {
+	if len(result.ColumnNames) != 1 || len(result.Records) != 1 {
+		return result
+	}
+
+	var document []byte
+	switch value := result.Records[0].([]interface{})[0].(type) {
+	case string:
+		document = []byte(value)
+	case []byte:
+		document = value
+	default:
+		return result
+	}
+
+	var raw interface{}
+	if err := jsoniter.Unmarshal(document, &raw); err != nil {
+		return result
+	}
+	return raw
+}
+
+// postgresPlanTree reads the output of EXPLAIN (FORMAT JSON), a list of {"Plan": node}.
+func postgresPlanTree(raw interface{}) ([]*PlanNode, error) {
+	plans, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of plans")
+	}
+
+	var tree []*PlanNode
+	for _, plan := range plans {
+		object, _ := plan.(map[string]interface{})
+		node, ok := object["Plan"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected a Plan object")
+		}
+		tree = append(tree, postgresPlanNode(node))
+	}
+	return tree, nil
+}
+
+func postgresPlanNode(node map[string]interface{}) *PlanNode {
+	planNode := &PlanNode{
+		Operation:  fmt.Sprint(node["Node Type"]),
+		Properties: map[string]interface{}{},
+	}
+	if relation, ok := node["Relation Name"].(string); ok {
+		planNode.Detail = relation
+	}
+
+	for key, value := range node {
+		switch key {
+		case "Node Type", "Relation Name":
+		case "Plans":
+			children, _ := value.([]interface{})
+			for _, child := range children {
+				if child, ok := child.(map[string]interface{}); ok {
+					planNode.Children = append(planNode.Children, postgresPlanNode(child))
+				}
+			}
+		default:
+			planNode.Properties[key] = value
+		}
+	}
+	return planNode
+}
+
+// mysqlPlanTree reads the output of EXPLAIN FORMAT=JSON, a {"query_block": ...} object
+// where operations nest tables and other operations.
+func mysqlPlanTree(raw interface{}) ([]*PlanNode, error) {
+	document, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected an object")
+	}
+
+	var tree []*PlanNode
+	for _, key := range sortedKeys(document) {
+		if object, ok := document[key].(map[string]interface{}); ok {
+			tree = append(tree, mysqlPlanNode(key, object))
+		}
+	}
+	return tree, nil
+}
+
+func mysqlPlanNode(operation string, object map[string]interface{}) *PlanNode {
+	planNode := &PlanNode{
+		Operation:  operation,
+		Properties: map[string]interface{}{},
+	}
+	if table, ok := object["table_name"].(string); ok {
+		planNode.Detail = table
+	}
+
+	for _, key := range sortedKeys(object) {
+		switch value := object[key].(type) {
+		case map[string]interface{}:
+			// Objects of plain values such as cost_info are attributes, the others are operations.
+			if key == "table" || lo.SomeBy(lo.Values(value), isJSONContainer) {
+				planNode.Children = append(planNode.Children, mysqlPlanNode(key, value))
+			} else {
+				planNode.Properties[key] = value
+			}
+		case []interface{}:
+			if !lo.SomeBy(value, isJSONContainer) {
+				planNode.Properties[key] = value
+				continue
+			}
+			// Lists such as nested_loop hold {"table": ...} or {"query_block": ...} items.
+			list := &PlanNode{Operation: key}
+			for _, item := range value {
+				object, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if len(object) == 1 {
+					itemKey := lo.Keys(object)[0]
+					if itemObject, ok := object[itemKey].(map[string]interface{}); ok {
+						list.Children = append(list.Children, mysqlPlanNode(itemKey, itemObject))
+						continue
+					}
+				}
+				list.Children = append(list.Children, mysqlPlanNode(key, object))
+			}
+			planNode.Children = append(planNode.Children, list)
+		case nil:
+		default:
+			if key != "table_name" {
+				planNode.Properties[key] = value
+			}
+		}
+	}
+	return planNode
+}
+
+func isJSONContainer(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+	return false
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := lo.Keys(object)
+	sort.Strings(keys)
+	return keys
+}
+
+// odpsCostTree reads the {"Cost": {"SQLSummary": ...}} estimate of MaxCompute, which
+// covers the whole query in a single node.
+func odpsCostTree(raw interface{}) ([]*PlanNode, error) {
+	document, _ := raw.(map[string]interface{})
+	cost, _ := document["Cost"].(map[string]interface{})
+	summary, ok := cost["SQLSummary"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected a Cost.SQLSummary object")
+	}
+	return []*PlanNode{{Operation: "SQLSummary", Properties: summary}}, nil
+}
+
+var sqliteScan = regexp.MustCompile(`^(SCAN|SEARCH) (.+)$`)
+
+// sqlitePlanTree reads the rows of EXPLAIN QUERY PLAN: id, parent, notused, detail.
+func sqlitePlanTree(raw interface{}) ([]*PlanNode, error) {
+	result, ok := raw.(*QueryResult)
+	if !ok || len(result.ColumnNames) < 4 {
+		return nil, errors.New("expected id, parent, notused and detail columns")
+	}
+
+	var tree []*PlanNode
+	nodes := map[string]*PlanNode{}
+	for _, record := range result.Records {
+		row := record.([]interface{})
+		id, parent, detail := planValue(row[0]), planValue(row[1]), planValue(row[3])
+
+		node := &PlanNode{Operation: detail}
+		if match := sqliteScan.FindStringSubmatch(detail); match != nil {
+			node.Operation, node.Detail = match[1], match[2]
+		}
+		nodes[id] = node
+
+		if parentNode, ok := nodes[parent]; ok {
+			parentNode.Children = append(parentNode.Children, node)
+		} else {
+			tree = append(tree, node)
+		}
+	}
+	return tree, nil
+}
+
+func planValue(value interface{}) string {
+	switch value := value.(type) {
+	case []byte:
+		return string(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	}
+	return fmt.Sprint(value)
+}
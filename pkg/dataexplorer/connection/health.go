@@ -0,0 +1,109 @@
+package connection
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	HealthUnknown = "unknown"
+	HealthUp      = "up"
+	HealthDown    = "down"
+)
+
+type Health struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Latency   int64      `json:"latency"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+type TestResult struct {
+	Latency       time.Duration
+	ServerVersion string
+}
+
+// Test pings the database and reads the server version when the driver knows how.
+func (connection *Connection) Test(ctx context.Context) (*TestResult, error) {
+	startTime := time.Now()
+	if err := connection.DB.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	result := TestResult{
+		Latency: time.Since(startTime),
+	}
+
+	if connection.Driver.VersionQuery != "" {
+		if err := connection.DB.GetContext(ctx, &result.ServerVersion, connection.Driver.VersionQuery); err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+// TestConnection opens the connection if needed, tests it and records the outcome as its health.
+func (holder *ConnectionHolder) TestConnection(ctx context.Context, id string) (*TestResult, error) {
+	result, err := holder.testConnection(ctx, id)
+
+	checkedAt := time.Now()
+	health := Health{
+		Status:    HealthUp,
+		CheckedAt: &checkedAt,
+	}
+	if err != nil {
+		health.Status = HealthDown
+		health.Error = err.Error()
+	} else {
+		health.Latency = result.Latency.Milliseconds()
+	}
+
+	holder.mu.Lock()
+	holder.health[id] = health
+	holder.mu.Unlock()
+
+	return result, err
+}
+
+func (holder *ConnectionHolder) testConnection(ctx context.Context, id string) (*TestResult, error) {
+	connection, release, err := holder.acquireConnection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return connection.Test(ctx)
+}
+
+// Health returns the last recorded health of the connection.
+func (holder *ConnectionHolder) Health(id string) Health {
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	if health, ok := holder.health[id]; ok {
+		return health
+	}
+	return Health{Status: HealthUnknown}
+}
+
+// MonitorHealth tests every configured connection each interval until ctx is done.
+func (holder *ConnectionHolder) MonitorHealth(ctx context.Context, interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, configuration := range holder.ConnectionConfigurations() {
+			testCtx, cancel := context.WithTimeout(ctx, timeout)
+			if _, err := holder.TestConnection(testCtx, configuration.Id); err != nil {
+				log.Printf("connection %s is unhealthy: %v", configuration.Id, err)
+			}
+			cancel()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package connection
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/samber/lo"
+)
+
+// Binary is a binary value of a result. It is marshalled as {"$binary": "<base64>"}
+// so that clients can tell it from text.
+type Binary []byte
+
+func (binary Binary) MarshalJSON() ([]byte, error) {
+	return jsoniter.Marshal(map[string]string{
+		"$binary": base64.StdEncoding.EncodeToString(binary),
+	})
+}
+
+// columnKind groups database types whose values need the same normalization.
+type columnKind int
+
+const (
+	otherColumn columnKind = iota
+	binaryColumn
+	decimalColumn
+	dateColumn
+	timeColumn
+)
+
+var (
+	binaryTypes  = []string{"BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BYTEA", "BINARY", "VARBINARY", "BIT", "GEOMETRY"}
+	decimalTypes = []string{"DECIMAL", "NUMERIC", "NEWDECIMAL", "NUMBER", "HUGEINT", "UHUGEINT"}
+	timeTypes    = []string{"DATETIME", "TIMESTAMP", "TIMESTAMPTZ", "TIMESTAMP_TZ", "TIMESTAMP_NTZ"}
+)
+
+// columnKindOf classifies a column by its database type, ignoring its parameters
+// such as the precision of DECIMAL(10,2) or the items of ARRAY<INT>.
+func columnKindOf(columnType *sql.ColumnType) columnKind {
+	name := strings.ToUpper(columnType.DatabaseTypeName())
+	if index := strings.IndexAny(name, "(<"); index >= 0 {
+		name = name[:index]
+	}
+	name = strings.TrimSpace(name)
+
+	switch {
+	case lo.Contains(binaryTypes, name):
+		return binaryColumn
+	case lo.Contains(decimalTypes, name):
+		return decimalColumn
+	case name == "DATE":
+		return dateColumn
+	case lo.Contains(timeTypes, name):
+		return timeColumn
+	}
+	return otherColumn
+}
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?([eE][+-]?\d+)?$`)
+
+// textTimeLayout is how MySQL sends DATETIME and TIMESTAMP values without parseTime.
+const textTimeLayout = "2006-01-02 15:04:05.999999999"
+
+// normalizeValue turns a scanned value into one that encodes the same way in JSON
+// whatever the driver: text as strings, binary as Binary, decimals as exact JSON
+// numbers, dates as 2006-01-02 and times as RFC 3339. Values JSON cannot hold, such
+// as NaN or a decimal too large for a float, are kept as strings.
+func normalizeValue(value interface{}, kind columnKind) interface{} {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if kind == binaryColumn || !utf8.Valid(value) {
+			return Binary(value)
+		}
+		return normalizeText(string(value), kind)
+	case string:
+		return normalizeText(value, kind)
+	case time.Time:
+		if kind == dateColumn {
+			return value.Format(time.DateOnly)
+		}
+		return value.Format(time.RFC3339Nano)
+	case float64:
+		return normalizeFloat(value, 64)
+	case float32:
+		return normalizeFloat(float64(value), 32)
+	case *big.Int:
+		return json.Number(value.String())
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return value
+	case fmt.Stringer:
+		// Driver specific decimals, such as the ones of MaxCompute.
+		if kind == decimalColumn {
+			return normalizeText(value.String(), kind)
+		}
+	}
+	return value
+}
+
+func normalizeText(text string, kind columnKind) interface{} {
+	switch kind {
+	case decimalColumn:
+		if jsonNumber.MatchString(text) {
+			return json.Number(text)
+		}
+	case timeColumn:
+		if t, err := time.ParseInLocation(textTimeLayout, text, time.UTC); err == nil {
+			return t.Format(time.RFC3339Nano)
+		}
+	}
+	return text
+}
+
+func normalizeFloat(value float64, bitSize int) interface{} {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return strconv.FormatFloat(value, 'g', -1, bitSize)
+	}
+	return value
+}
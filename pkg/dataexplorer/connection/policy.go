@@ -0,0 +1,193 @@
+package connection
+
+import (
+	"data-explorer/pkg/dataexplorer/conf"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// ReadStatements are the statement types read-only connections allow.
+var ReadStatements = []string{"SELECT", "WITH", "EXPLAIN", "SHOW", "DESCRIBE", "DESC", "VALUES", "TABLE"}
+
+// writeKeywords start the statements that change data, which may hide in WITH and EXPLAIN ANALYZE.
+var writeKeywords = []string{"INSERT", "UPDATE", "DELETE", "MERGE"}
+
+// StatementNotAllowedError refuses a statement the policy of the connection does not allow.
+type StatementNotAllowedError struct {
+	ConnectionId string
+	Type         string
+	Allowed      []string
+}
+
+func (err *StatementNotAllowedError) Error() string {
+	return fmt.Sprintf(
+		"connection %s does not allow %s statements, only %s",
+		err.ConnectionId,
+		err.Type,
+		strings.Join(err.Allowed, ", "),
+	)
+}
+
+// AllowedStatements returns the statement types a connection runs: its allowed_statements,
+// ReadStatements when it is read-only, or nil when everything is allowed.
+func AllowedStatements(configuration conf.Connection) []string {
+	if len(configuration.AllowedStatements) > 0 {
+		return lo.Map(configuration.AllowedStatements, func(statementType string, index int) string {
+			return strings.ToUpper(strings.TrimSpace(statementType))
+		})
+	}
+	if configuration.ReadOnly {
+		return ReadStatements
+	}
+	return nil
+}
+
+// CheckStatements returns a *StatementNotAllowedError for the first statement whose type
+// the connection does not allow.
+func (connection *Connection) CheckStatements(statements []string) error {
+	allowed := AllowedStatements(connection.Configuration)
+	if allowed == nil {
+		return nil
+	}
+
+	for _, statement := range statements {
+		statementType := ClassifyStatement(statement, connection.Driver.Dialect)
+		if !lo.Contains(allowed, statementType) {
+			return &StatementNotAllowedError{
+				ConnectionId: connection.Id,
+				Type:         statementType,
+				Allowed:      allowed,
+			}
+		}
+	}
+	return nil
+}
+
+// ClassifyStatement returns the type of a single statement in upper case, which is its
+// first keyword except for:
+//   - WITH, which is the type of its main statement or of its first CTE that changes
+//     data, and WITH when it only reads,
+//   - EXPLAIN ANALYZE, which runs the statement, so it takes the type of a statement
+//     that changes data,
+//   - SELECT ... INTO, which creates a table or a file and is SELECT INTO.
+//
+// It returns an empty string when the statement has no keyword.
+func ClassifyStatement(statement string, dialect Dialect) string {
+	return classify(statementWords(statement, dialect))
+}
+
+func classify(words []statementWord) string {
+	if len(words) == 0 {
+		return ""
+	}
+
+	first := words[0]
+	switch first.text {
+	case "WITH":
+		for _, word := range words[1:] {
+			if word.afterParen && lo.Contains(writeKeywords, word.text) {
+				return word.text
+			}
+		}
+		for _, word := range words[1:] {
+			if word.depth != first.depth {
+				continue
+			}
+			if lo.Contains(writeKeywords, word.text) {
+				return word.text
+			}
+			if word.text == "SELECT" || word.text == "VALUES" || word.text == "TABLE" {
+				return first.text
+			}
+		}
+	case "EXPLAIN":
+		analyze := false
+		for index, word := range words[1:] {
+			if word.text == "ANALYZE" || word.text == "ANALYSE" {
+				analyze = true
+				continue
+			}
+			if word.text == "SELECT" || word.text == "WITH" || lo.Contains(writeKeywords, word.text) {
+				if explained := classify(words[1+index:]); analyze && !lo.Contains(ReadStatements, explained) {
+					return explained
+				}
+				break
+			}
+		}
+	case "SELECT":
+		for _, word := range words[1:] {
+			if word.depth == first.depth && word.text == "INTO" {
+				return "SELECT INTO"
+			}
+		}
+	}
+	return first.text
+}
+
+// statementWord is an unquoted word of a statement in upper case, with the depth of
+// parentheses it appears at.
+type statementWord struct {
+	text  string
+	depth int
+	// afterParen is set when the word comes right after an opening parenthesis.
+	afterParen bool
+}
+
+// statementWords lists the words of the statement outside of quotes and comments.
+func statementWords(statement string, dialect Dialect) []statementWord {
+	var words []statementWord
+	depth := 0
+	afterParen := false
+
+	for _, segment := range Segments(statement, dialect) {
+		switch segment.Kind {
+		case QuotedSegment:
+			afterParen = false
+			continue
+		case CommentSegment:
+			continue
+		}
+
+		for i := segment.Start; i < segment.End; {
+			c := statement[i]
+			switch {
+			case c == '(':
+				depth++
+				afterParen = true
+				i++
+			case c == ')':
+				depth--
+				afterParen = false
+				i++
+			case isWordStart(c):
+				end := i + 1
+				for end < segment.End && (isWordChar(statement[end]) || statement[end] == '$') {
+					end++
+				}
+				words = append(words, statementWord{
+					text:       strings.ToUpper(statement[i:end]),
+					depth:      depth,
+					afterParen: afterParen,
+				})
+				afterParen = false
+				i = end
+			case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+				i++
+			default:
+				afterParen = false
+				i++
+			}
+		}
+	}
+	return words
+}
+
+func isWordStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isWordChar(c byte) bool {
+	return isWordStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,90 @@
+package connection
+
+import (
+	"data-explorer/pkg/dataexplorer/conf"
+	"errors"
+	"testing"
+)
+
+var (
+	mysqlDialect    = Dialect{BackslashEscapes: true, HashComments: true, ExecutableComments: true}
+	postgresDialect = Dialect{DollarQuotes: true, EscapeStrings: true}
+)
+
+func TestClassifyStatement(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		dialect   Dialect
+		want      string
+	}{
+		{"select", "select * from t", postgresDialect, "SELECT"},
+		{"leading comments", "-- note\n/* block */ SELECT 1", postgresDialect, "SELECT"},
+		{"hash comment", "# DELETE FROM t\nSELECT 1", mysqlDialect, "SELECT"},
+		{"lower case", "delete from t", postgresDialect, "DELETE"},
+		{"keyword in string", "SELECT 'DELETE FROM t'", postgresDialect, "SELECT"},
+		{"keyword in dollar quotes", "SELECT $$ DELETE $$", postgresDialect, "SELECT"},
+		{"keyword in escape string", `SELECT E'\' DELETE'`, postgresDialect, "SELECT"},
+		{"read only with", "WITH a AS (SELECT 1) SELECT * FROM a", postgresDialect, "WITH"},
+		{"with delete", "WITH a AS (SELECT 1) DELETE FROM t WHERE id IN (SELECT * FROM a)", postgresDialect, "DELETE"},
+		{"with writing cte", "WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", postgresDialect, "DELETE"},
+		{"explain", "EXPLAIN DELETE FROM t", postgresDialect, "EXPLAIN"},
+		{"explain analyze select", "EXPLAIN ANALYZE SELECT 1", postgresDialect, "EXPLAIN"},
+		{"explain analyze delete", "EXPLAIN ANALYZE DELETE FROM t", postgresDialect, "DELETE"},
+		{"explain options analyze", "EXPLAIN (ANALYZE, FORMAT JSON) UPDATE t SET a = 1", postgresDialect, "UPDATE"},
+		{"select into", "SELECT * INTO copy FROM t", postgresDialect, "SELECT INTO"},
+		{"nested into", "SELECT (SELECT 1) FROM t", postgresDialect, "SELECT"},
+		{"executable comment", "/*!40000 DROP TABLE t */", mysqlDialect, "DROP"},
+		{"mariadb executable comment", "/*M!100100 DELETE FROM t */", mysqlDialect, "DELETE"},
+		{"executable comment after select", "SELECT 1 /*! INTO OUTFILE '/tmp/t' */", mysqlDialect, "SELECT INTO"},
+		{"executable comment elsewhere", "/*!40000 DROP TABLE t */", postgresDialect, ""},
+		{"optimizer hint", "SELECT /*+ MAX_EXECUTION_TIME(1) */ 1", mysqlDialect, "SELECT"},
+		{"no keyword", "-- nothing", postgresDialect, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ClassifyStatement(test.statement, test.dialect); got != test.want {
+				t.Errorf("ClassifyStatement(%q) = %q, want %q", test.statement, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckStatements(t *testing.T) {
+	tests := []struct {
+		name          string
+		configuration conf.Connection
+		statements    []string
+		wantType      string
+	}{
+		{"unrestricted", conf.Connection{}, []string{"DROP TABLE t"}, ""},
+		{"read only select", conf.Connection{ReadOnly: true}, []string{"SELECT 1", "SHOW TABLES"}, ""},
+		{"read only delete", conf.Connection{ReadOnly: true}, []string{"SELECT 1", "DELETE FROM t"}, "DELETE"},
+		{"read only executable comment", conf.Connection{ReadOnly: true}, []string{"/*!40000 DROP TABLE t */"}, "DROP"},
+		{"escape string", conf.Connection{AllowedStatements: []string{"select"}}, SplitStatements(`SELECT E'\''; DROP TABLE t; --'`, postgresDialect), "DROP"},
+		{"allowed statements", conf.Connection{AllowedStatements: []string{"select", " insert "}}, []string{"INSERT INTO t VALUES (1)"}, ""},
+		{"allowed statements refuse", conf.Connection{AllowedStatements: []string{"select"}}, []string{"UPDATE t SET a = 1"}, "UPDATE"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			connection := &Connection{
+				Id:            "test",
+				Driver:        &Driver{Dialect: mysqlDialect},
+				Configuration: test.configuration,
+			}
+
+			err := connection.CheckStatements(test.statements)
+			var notAllowed *StatementNotAllowedError
+			switch {
+			case test.wantType == "" && err != nil:
+				t.Errorf("CheckStatements() = %v, want nil", err)
+			case test.wantType != "" && !errors.As(err, &notAllowed):
+				t.Errorf("CheckStatements() = %v, want a *StatementNotAllowedError", err)
+			case test.wantType != "" && notAllowed.Type != test.wantType:
+				t.Errorf("CheckStatements() refused %s, want %s", notAllowed.Type, test.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/samber/lo"
+)
+
+// Capabilities describes optional features a database supports.
+type Capabilities struct {
+	Cancel               bool `json:"cancel"`
+	Explain              bool `json:"explain"`
+	Schemas              bool `json:"schemas"`
+	ReadOnlyTransactions bool `json:"read_only_transactions"`
+	// Cost tells whether queries can be estimated before they run, see Driver.EstimateCost.
+	Cost bool `json:"cost"`
+}
+
+// Driver knows how to turn a DSN from connections.yaml into a database/sql connection.
+type Driver struct {
+	// Name is the database/sql driver name passed to sqlx.Open.
+	Name string
+	// Schemes are the URL schemes (without "://") handled by this driver.
+	Schemes []string
+	// Match is an optional fallback for DSNs that have no distinctive scheme.
+	Match func(dsn string) bool
+	// TranslateDSN converts the configured DSN into the form the driver expects.
+	TranslateDSN func(dsn string) (string, error)
+	// VersionQuery returns the server version as a single value, empty if unsupported.
+	VersionQuery string
+	// Placeholder returns the bind placeholder of the index-th argument, counting
+	// from 1. It is nil when the driver does not take query arguments.
+	Placeholder func(index int) string
+	// Dialect tells SplitStatements how to find the end of a statement.
+	Dialect Dialect
+	// Explainer gets query plans, nil if the driver cannot.
+	Explainer *Explainer
+	// EstimateCost tells what a query would read without running it, nil if unsupported.
+	EstimateCost func(ctx context.Context, connection *Connection, query string) (*Cost, error)
+	// Killer stops running statements on the server, nil if cancelling the context is all the driver supports.
+	Killer       *Killer
+	Capabilities Capabilities
+}
+
+func questionPlaceholder(index int) string {
+	return "?"
+}
+
+func dollarPlaceholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+// Killer stops a statement on the server from outside the connection that runs it.
+type Killer struct {
+	// Prepare runs on the connection that is about to execute query. It returns
+	// the query to execute and a handle that identifies it for Kill.
+	Prepare func(ctx context.Context, conn *sqlx.Conn, query string) (string, string, error)
+	// Kill stops the statement identified by handle.
+	Kill func(ctx context.Context, connection *Connection, handle string) error
+}
+
+// Open opens a pool for dsn and pings the database within ctx.
+func (driver *Driver) Open(ctx context.Context, dsn string) (*sqlx.DB, error) {
+	driverDSN := dsn
+	if driver.TranslateDSN != nil {
+		var err error
+		if driverDSN, err = driver.TranslateDSN(dsn); err != nil {
+			return nil, err
+		}
+	}
+
+	if !lo.Contains(sql.Drivers(), driver.Name) {
+		return nil, fmt.Errorf("database driver %s is not available in this build", driver.Name)
+	}
+	db, err := sqlx.Open(driver.Name, driverDSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+var registry = struct {
+	mu      sync.RWMutex
+	drivers []*Driver
+	schemes map[string]*Driver
+}{
+	schemes: map[string]*Driver{},
+}
+
+// RegisterDriver makes a driver available to NewDB. It panics if a scheme is registered twice.
+func RegisterDriver(driver *Driver) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, scheme := range driver.Schemes {
+		if _, ok := registry.schemes[scheme]; ok {
+			panic(fmt.Sprintf("connection: scheme %s is already registered", scheme))
+		}
+		registry.schemes[scheme] = driver
+	}
+	registry.drivers = append(registry.drivers, driver)
+}
+
+// LookupDriver finds the driver responsible for the DSN, by scheme first and then by Match.
+func LookupDriver(dsn string) (*Driver, error) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	scheme, _, hasScheme := strings.Cut(dsn, "://")
+	if hasScheme {
+		if driver, ok := registry.schemes[strings.ToLower(scheme)]; ok {
+			return driver, nil
+		}
+	}
+
+	for _, driver := range registry.drivers {
+		if driver.Match != nil && driver.Match(dsn) {
+			return driver, nil
+		}
+	}
+
+	if hasScheme {
+		return nil, fmt.Errorf("invalid DSN: no driver registered for scheme %s", scheme)
+	}
+	return nil, fmt.Errorf("invalid DSN: missing scheme")
+}
+
+// trimScheme removes the leading "scheme://" from the DSN.
+func trimScheme(dsn string) string {
+	if _, rest, ok := strings.Cut(dsn, "://"); ok {
+		return rest
+	}
+	return dsn
+}
@@ -0,0 +1,273 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/samber/lo"
+)
+
+// Dialect holds the lexical rules of a database that matter to tell statements apart.
+// Single, double and back quotes, -- and /* */ comments are understood everywhere.
+type Dialect struct {
+	// BackslashEscapes lets a backslash escape the next character in quoted strings.
+	BackslashEscapes bool
+	// HashComments starts a line comment with #.
+	HashComments bool
+	// DollarQuotes quotes strings with $$ or $tag$.
+	DollarQuotes bool
+	// EscapeStrings lets a backslash escape the next character in E'' strings.
+	EscapeStrings bool
+	// ExecutableComments runs the content of /*! */ and /*M! */ comments as code,
+	// optionally after a version number.
+	ExecutableComments bool
+}
+
+var executableComment = regexp.MustCompile(`^/\*M?!\d*`)
+
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_]\w*)?\$`)
+
+// SegmentKind tells code from quoted text and comments.
+type SegmentKind int
+
+const (
+	CodeSegment SegmentKind = iota
+	// QuotedSegment is a quoted string or identifier, its quotes included.
+	QuotedSegment
+	CommentSegment
+)
+
+// Segment is the part of a script from Start to End.
+type Segment struct {
+	Kind  SegmentKind
+	Start int
+	End   int
+}
+
+// Segments cuts script into code, quoted text and comments following dialect.
+func Segments(script string, dialect Dialect) []Segment {
+	var segments []Segment
+	code := 0
+	executable := false
+
+	for i := 0; i < len(script); {
+		c := script[i]
+		kind, end := CommentSegment, 0
+		switch {
+		case strings.HasPrefix(script[i:], "--") || (c == '#' && dialect.HashComments):
+			end = skipPast(script, i, "\n", 0)
+		case dialect.ExecutableComments && !executable && executableComment.MatchString(script[i:]):
+			// Only the markers of executable comments are comments, what they hold is code.
+			end = i + len(executableComment.FindString(script[i:]))
+			executable = true
+		case executable && strings.HasPrefix(script[i:], "*/"):
+			end = i + 2
+			executable = false
+		case strings.HasPrefix(script[i:], "/*"):
+			end = skipPast(script, i, "*/", 2)
+		case (c == 'E' || c == 'e') && dialect.EscapeStrings && (i == 0 || !isWordChar(script[i-1])) &&
+			i+1 < len(script) && script[i+1] == '\'':
+			kind, end = QuotedSegment, quoteEnd(script, i+1, true)
+		case c == '\'' || c == '"' || c == '`':
+			kind, end = QuotedSegment, quoteEnd(script, i, dialect.BackslashEscapes && c != '`')
+		case c == '$' && dialect.DollarQuotes && (i == 0 || !isWordChar(script[i-1])) && dollarTag.MatchString(script[i:]):
+			tag := dollarTag.FindString(script[i:])
+			kind, end = QuotedSegment, skipPast(script, i, tag, len(tag))
+		default:
+			i++
+			continue
+		}
+
+		if code < i {
+			segments = append(segments, Segment{Kind: CodeSegment, Start: code, End: i})
+		}
+		segments = append(segments, Segment{Kind: kind, Start: i, End: end})
+		i, code = end, end
+	}
+
+	if code < len(script) {
+		segments = append(segments, Segment{Kind: CodeSegment, Start: code, End: len(script)})
+	}
+	return segments
+}
+
+// SplitStatements splits a script on the semicolons that are outside of quotes and
+// comments. Statements are trimmed and those with nothing but comments are dropped.
+func SplitStatements(script string, dialect Dialect) []string {
+	var statements []string
+	start := 0
+	hasCode := false
+
+	for _, segment := range Segments(script, dialect) {
+		switch segment.Kind {
+		case QuotedSegment:
+			hasCode = true
+		case CodeSegment:
+			for i := segment.Start; i < segment.End; i++ {
+				if script[i] != ';' {
+					hasCode = hasCode || !unicode.IsSpace(rune(script[i]))
+					continue
+				}
+				if hasCode {
+					statements = append(statements, strings.TrimSpace(script[start:i]))
+				}
+				start = i + 1
+				hasCode = false
+			}
+		}
+	}
+
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(script[start:]))
+	}
+	return statements
+}
+
+// skipPast returns the index right after the first end found after from+offset, or the end of s.
+func skipPast(s string, from int, end string, offset int) int {
+	index := strings.Index(s[from+offset:], end)
+	if index < 0 {
+		return len(s)
+	}
+	return from + offset + index + len(end)
+}
+
+// quoteEnd returns the index right after the quoted string starting at from. A doubled
+// quote stands for the quote itself.
+func quoteEnd(s string, from int, backslashEscapes bool) int {
+	quote := s[from]
+	for i := from + 1; i < len(s); i++ {
+		switch {
+		case backslashEscapes && s[i] == '\\':
+			i++
+		case s[i] == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// rowsKeywords start the statements that produce a result set.
+var rowsKeywords = []string{"SELECT", "WITH", "SHOW", "DESCRIBE", "DESC", "EXPLAIN", "VALUES", "PRAGMA", "TABLE", "FROM"}
+
+// returnsRows tells whether a statement produces a result set rather than an affected
+// row count: a query, a parenthesized one, or a change of data with RETURNING.
+func returnsRows(statement string, dialect Dialect) bool {
+	words := statementWords(statement, dialect)
+	if len(words) == 0 {
+		return false
+	}
+	if words[0].depth > 0 || lo.Contains(rowsKeywords, words[0].text) {
+		return true
+	}
+	return lo.ContainsBy(words, func(word statementWord) bool {
+		return word.depth == 0 && word.text == "RETURNING"
+	})
+}
+
+// StatementResult is the outcome of one statement of a script.
+type StatementResult struct {
+	Statement string `json:"statement"`
+	// Result holds the rows of statements that return some.
+	Result *QueryResult `json:"result,omitempty"`
+	// RowsAffected is set for the other statements when the driver reports it.
+	RowsAffected *int64 `json:"rows_affected,omitempty"`
+	// Duration is in milliseconds.
+	Duration int64  `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Script runs statements one after the other on a single session, so that SET and
+// temporary tables apply to the statements that follow. It stops at the first error
+// and returns the results so far, the failing statement included.
+func (connection *Connection) Script(ctx context.Context, statements []string, options QueryOptions) ([]*StatementResult, error) {
+	configuration := connection.Configuration
+	maxRows := EffectiveLimit(configuration.MaxRows, options.MaxRows)
+
+	if configuration.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, configuration.QueryTimeout)
+		defer cancel()
+	}
+
+	conn, err := connection.DB.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var runner interface {
+		sqlx.QueryerContext
+		sqlx.ExecerContext
+	} = conn
+	if configuration.ReadOnly && connection.Driver.Capabilities.ReadOnlyTransactions {
+		tx, err := conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		runner = tx
+	}
+
+	var results []*StatementResult
+	for _, statement := range statements {
+		result := &StatementResult{Statement: statement}
+		results = append(results, result)
+
+		startTime := time.Now()
+		err := connection.runStatement(ctx, conn, runner, statement, maxRows, options, result)
+		result.Duration = time.Since(startTime).Milliseconds()
+		if err != nil {
+			result.Error = err.Error()
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (connection *Connection) runStatement(
+	ctx context.Context,
+	conn *sqlx.Conn,
+	runner interface {
+		sqlx.QueryerContext
+		sqlx.ExecerContext
+	},
+	statement string,
+	maxRows int,
+	options QueryOptions,
+	result *StatementResult,
+) error {
+	query := statement
+	if connection.Driver.Killer != nil && options.Kill {
+		running, preparedQuery, err := newRunningStatement(ctx, connection, conn, query)
+		if err != nil {
+			return err
+		}
+		defer running.finish()
+		query = preparedQuery
+	}
+
+	if returnsRows(statement, connection.Driver.Dialect) {
+		queryResult, err := Query(ctx, runner, query, maxRows)
+		result.Result = queryResult
+		return err
+	}
+
+	execResult, err := runner.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, err := execResult.RowsAffected(); err == nil {
+		result.RowsAffected = &rowsAffected
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package connection
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		dialect Dialect
+		want    []string
+	}{
+		{"single", "SELECT 1", Dialect{}, []string{"SELECT 1"}},
+		{"trailing semicolon", "SELECT 1;", Dialect{}, []string{"SELECT 1"}},
+		{"several", "SELECT 1;\n  SELECT 2 ;SELECT 3", Dialect{}, []string{"SELECT 1", "SELECT 2", "SELECT 3"}},
+		{"empty statements", ";;SELECT 1;;", Dialect{}, []string{"SELECT 1"}},
+		{"comment only", "SELECT 1; -- done\n/* really */", Dialect{}, []string{"SELECT 1"}},
+		{"semicolon in string", "SELECT ';'; SELECT 2", Dialect{}, []string{"SELECT ';'", "SELECT 2"}},
+		{"doubled quote", "SELECT 'it''s;'; SELECT 2", Dialect{}, []string{"SELECT 'it''s;'", "SELECT 2"}},
+		{"semicolon in identifiers", "SELECT \"a;b\", `c;d`", Dialect{}, []string{"SELECT \"a;b\", `c;d`"}},
+		{"semicolon in comments", "SELECT 1 -- a; b\n; /* c; d */ SELECT 2", Dialect{}, []string{"SELECT 1 -- a; b", "/* c; d */ SELECT 2"}},
+		{"backslash escape", `SELECT 'a\';'; SELECT 2`, mysqlDialect, []string{`SELECT 'a\';'`, "SELECT 2"}},
+		{"backslash without escapes", `SELECT 'a\'; SELECT 2`, postgresDialect, []string{`SELECT 'a\'`, "SELECT 2"}},
+		{"escape string", `SELECT E'\''; DROP TABLE t; --'`, postgresDialect, []string{`SELECT E'\''`, "DROP TABLE t"}},
+		{"escape string in identifier", `SELECT type'a\'; SELECT 2`, postgresDialect, []string{`SELECT type'a\'`, "SELECT 2"}},
+		{"hash comment", "SELECT 1 # a;\n; SELECT 2", mysqlDialect, []string{"SELECT 1 # a;", "SELECT 2"}},
+		{"hash without comments", "SELECT 1 # 2; SELECT 2", postgresDialect, []string{"SELECT 1 # 2", "SELECT 2"}},
+		{"dollar quotes", "CREATE FUNCTION f() AS $$ BEGIN; END $$; SELECT 2", postgresDialect, []string{"CREATE FUNCTION f() AS $$ BEGIN; END $$", "SELECT 2"}},
+		{"tagged dollar quotes", "SELECT $fn$ a; $$ b; $fn$; SELECT 2", postgresDialect, []string{"SELECT $fn$ a; $$ b; $fn$", "SELECT 2"}},
+		{"dollar in identifier", "SELECT a$b$; SELECT 2", postgresDialect, []string{"SELECT a$b$", "SELECT 2"}},
+		{"executable comment", "SELECT 1; /*!40000 DROP TABLE t */", mysqlDialect, []string{"SELECT 1", "/*!40000 DROP TABLE t */"}},
+		{"unterminated string", "SELECT 'a; SELECT 2", Dialect{}, []string{"SELECT 'a; SELECT 2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SplitStatements(test.script, test.dialect); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SplitStatements(%q) = %q, want %q", test.script, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReturnsRows(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      bool
+	}{
+		{"SELECT 1", true},
+		{"-- comment\nselect 1", true},
+		{"(SELECT 1) UNION (SELECT 2)", true},
+		{"WITH a AS (SELECT 1) SELECT * FROM a", true},
+		{"SHOW TABLES", true},
+		{"PRAGMA table_info(t)", true},
+		{"INSERT INTO t VALUES (1)", false},
+		{"INSERT INTO t VALUES (1) RETURNING id", true},
+		{"UPDATE t SET a = 'RETURNING' WHERE id = 1", false},
+		{"DELETE FROM t WHERE id = 1 returning *", true},
+		{"CREATE TABLE t (returning int)", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			if got := returnsRows(test.statement, postgresDialect); got != test.want {
+				t.Errorf("returnsRows(%q) = %v, want %v", test.statement, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package connection
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const killTimeout = 10 * time.Second
+
+// runningStatement kills a statement on the server once its context is done, whether
+// it was cancelled, timed out or its client went away: cancelling the context is not
+// enough for every driver, MySQL and MaxCompute keep running the statement. Once
+// finished, kill is a no-op so that a later statement reusing the pooled connection
+// or session is never hit.
+type runningStatement struct {
+	mu         sync.Mutex
+	connection *Connection
+	handle     string
+	done       bool
+	stopKill   func() bool
+	killed     chan struct{}
+}
+
+func newRunningStatement(ctx context.Context, connection *Connection, conn *sqlx.Conn, query string) (*runningStatement, string, error) {
+	query, handle, err := connection.Driver.Killer.Prepare(ctx, conn, query)
+	if err != nil {
+		return nil, "", err
+	}
+	statement := &runningStatement{
+		connection: connection,
+		handle:     handle,
+		killed:     make(chan struct{}),
+	}
+	statement.stopKill = context.AfterFunc(ctx, statement.kill)
+	return statement, query, nil
+}
+
+func (statement *runningStatement) kill() {
+	defer close(statement.killed)
+
+	statement.mu.Lock()
+	defer statement.mu.Unlock()
+
+	if statement.done {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+	defer cancel()
+	if err := statement.connection.Driver.Killer.Kill(ctx, statement.connection, statement.handle); err != nil {
+		log.Printf("failed to kill statement %s on connection %s: %v", statement.handle, statement.connection.Id, err)
+	}
+}
+
+func (statement *runningStatement) finish() {
+	if !statement.stopKill() {
+		// The context is done, the kill must reach the statement before it is finished.
+		<-statement.killed
+	}
+
+	statement.mu.Lock()
+	defer statement.mu.Unlock()
+
+	statement.done = true
+}
@@ -0,0 +1,62 @@
+package connection
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestRunningStatement(t *testing.T) {
+	var kills atomic.Int32
+	connection := &Connection{
+		Id: "test",
+		Driver: &Driver{
+			Killer: &Killer{
+				Prepare: func(ctx context.Context, conn *sqlx.Conn, query string) (string, string, error) {
+					return query, "42", nil
+				},
+				Kill: func(ctx context.Context, connection *Connection, handle string) error {
+					if handle != "42" {
+						t.Errorf("Kill(%q), want handle 42", handle)
+					}
+					kills.Add(1)
+					return nil
+				},
+			},
+		},
+	}
+
+	t.Run("finished", func(t *testing.T) {
+		kills.Store(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		statement, _, err := newRunningStatement(ctx, connection, nil, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		statement.finish()
+		cancel()
+		statement.kill()
+
+		if kills.Load() != 0 {
+			t.Errorf("a finished statement was killed %d times", kills.Load())
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		kills.Store(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		statement, _, err := newRunningStatement(ctx, connection, nil, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		// finish waits for the kill triggered by the cancellation.
+		statement.finish()
+
+		if kills.Load() != 1 {
+			t.Errorf("a cancelled statement was killed %d times, want once", kills.Load())
+		}
+	})
+}
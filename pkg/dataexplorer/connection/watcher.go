@@ -0,0 +1,60 @@
+package connection
+
+import (
+	"context"
+	"data-explorer/pkg/dataexplorer/conf"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch reloads the holder from path when the file changes or the process
+// receives SIGHUP, until ctx is done. Polling is disabled when interval is 0.
+// An invalid file is logged and the previous configuration is kept.
+func (holder *ConnectionHolder) Watch(ctx context.Context, path string, interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	lastModified := modificationTime(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			log.Printf("received SIGHUP, reloading %s", path)
+		case <-ticks:
+			modified := modificationTime(path)
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+			log.Printf("%s changed, reloading", path)
+		}
+
+		configuration, err := conf.LoadConnection(path)
+		if err != nil {
+			log.Printf("failed to reload %s, keeping the previous connections: %v", path, err)
+			continue
+		}
+		holder.Reload(configuration.Connections)
+	}
+}
+
+func modificationTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
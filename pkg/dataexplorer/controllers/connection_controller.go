@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"data-explorer/pkg/dataexplorer/conf"
+	"data-explorer/pkg/dataexplorer/connection"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+const connectionTestTimeout = 10 * time.Second
+
+type ConnectionController struct {
+	connectionHolder *connection.ConnectionHolder
+}
+
+func NewConnectionController(connectionHolder *connection.ConnectionHolder) *ConnectionController {
+	return &ConnectionController{
+		connectionHolder: connectionHolder,
+	}
+}
+
+// ConnectionResponse describes a connection without exposing its DSN.
+type ConnectionResponse struct {
+	ID           string                   `json:"id"`
+	Name         string                   `json:"name"`
+	Driver       string                   `json:"driver"`
+	Tags         []string                 `json:"tags"`
+	ReadOnly     bool                     `json:"read_only"`
+	Capabilities *connection.Capabilities `json:"capabilities,omitempty"`
+	Health       connection.Health        `json:"health"`
+
+	// ConfirmInputBytes is the estimated input above which queries must be confirmed.
+	ConfirmInputBytes int64 `json:"confirm_input_bytes,omitempty"`
+	// AllowedStatements are the statement types the connection runs, empty when it runs everything.
+	AllowedStatements []string `json:"allowed_statements,omitempty"`
+}
+
+func NewConnectionResponse(configuration *conf.Connection, health connection.Health) *ConnectionResponse {
+	response := ConnectionResponse{
+		ID:       configuration.Id,
+		Name:     configuration.Name,
+		Tags:     configuration.Tags,
+		ReadOnly: configuration.ReadOnly,
+		Health:   health,
+
+		ConfirmInputBytes: configuration.ConfirmInputBytes,
+		AllowedStatements: connection.AllowedStatements(*configuration),
+	}
+	if response.Name == "" {
+		response.Name = configuration.Id
+	}
+	if response.Tags == nil {
+		response.Tags = []string{}
+	}
+	if driver, err := connection.LookupDriver(configuration.DSN); err == nil {
+		response.Driver = driver.Name
+		response.Capabilities = &driver.Capabilities
+	}
+	return &response
+}
+
+type TestConnectionResponse struct {
+	ID            string `json:"id"`
+	OK            bool   `json:"ok"`
+	Latency       int64  `json:"latency"`
+	ServerVersion string `json:"server_version,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+func (controller *ConnectionController) ListConnections(c *gin.Context) {
+	response := lo.Map(controller.connectionHolder.ConnectionConfigurations(), func(item conf.Connection, index int) *ConnectionResponse {
+		return NewConnectionResponse(&item, controller.connectionHolder.Health(item.Id))
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+func (controller *ConnectionController) GetConnection(c *gin.Context) {
+	configuration, ok := controller.findConfiguration(c.Param("connectionId"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewConnectionResponse(configuration, controller.connectionHolder.Health(configuration.Id)))
+}
+
+func (controller *ConnectionController) TestConnection(c *gin.Context) {
+	configuration, ok := controller.findConfiguration(c.Param("connectionId"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, connectionTestTimeout)
+	defer cancel()
+
+	response := TestConnectionResponse{ID: configuration.Id}
+	result, err := controller.connectionHolder.TestConnection(ctx, configuration.Id)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.OK = true
+		response.Latency = result.Latency.Milliseconds()
+		response.ServerVersion = result.ServerVersion
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (controller *ConnectionController) findConfiguration(id string) (*conf.Connection, bool) {
+	configuration, ok := lo.Find(controller.connectionHolder.ConnectionConfigurations(), func(item conf.Connection) bool {
+		return item.Id == id
+	})
+	return &configuration, ok
+}
@@ -1,9 +1,12 @@
 package controllers
 
 import (
+	"data-explorer/pkg/dataexplorer/connection"
 	"data-explorer/pkg/dataexplorer/models"
 	"data-explorer/pkg/dataexplorer/repositories"
 	"data-explorer/pkg/dataexplorer/services"
+	"data-explorer/pkg/dataexplorer/template"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -24,6 +27,12 @@ type CreateQueryRequest struct {
 	Title        string            `json:"title"`
 	Query        string            `json:"query" binding:"required"`
 	Params       map[string]string `json:"params"`
+	Limit        int               `json:"limit"`
+	CompileRequest
+	ConfirmCostRequest
+
+	// Async returns a job right away instead of waiting for the result, see GetJob.
+	Async bool `json:"async"`
 }
 
 type CreateIssueSectionRequest struct {
@@ -35,15 +44,18 @@ type CreateIssueSectionRequest struct {
 type MainController struct {
 	repository   *repositories.Repository
 	queryService *services.QueryService
+	jobService   *services.JobService
 }
 
 func NewMainController(
 	issueRepository *repositories.Repository,
 	queryService *services.QueryService,
+	jobService *services.JobService,
 ) *MainController {
 	return &MainController{
 		repository:   issueRepository,
 		queryService: queryService,
+		jobService:   jobService,
 	}
 }
 
@@ -255,11 +267,64 @@ func (controller *MainController) PatchQuery(c *gin.Context) {
 		return
 	}
 
-	if err := controller.repository.PatchQuery(query, request); err != nil {
+	var dependsOn []uint64
+	if request.Query.HasValue() && request.Query.Value != nil {
+		patched := *query
+		patched.Query = *request.Query.Value
+		if dependsOn, err = controller.queryDependencies(&patched); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+			return
+		}
+	}
+
+	compile := func(patched *models.SQLQuery) (string, error) {
+		_, sql, _, err := controller.compileRun(patched, &RunQueryRequest{})
+		return sql, err
+	}
+	if err := controller.repository.PatchQuery(query, request, compile, dependsOn); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, repositories.ErrInvalidPatch) {
+			status = http.StatusBadRequest
+		}
+		c.AbortWithStatusJSON(status, NewErrorResponse(err))
+		return
+	}
+
+	if !request.Run {
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	query, err = controller.repository.FindQuery(queryId, &models.SQLQuery{})
+	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{})
+
+	controller.run(c, query, &RunQueryRequest{
+		Async:              request.Async,
+		ConfirmCostRequest: ConfirmCostRequest{ConfirmCost: request.ConfirmCost},
+	})
+}
+
+func (controller *MainController) ListRevisions(c *gin.Context) {
+	queryId, err := GetUint(c.Param("queryId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	var revisions []models.QueryRevision
+
+	if tx := controller.repository.DB.
+		Where(&models.QueryRevision{QueryID: queryId}).
+		Order("id DESC").
+		Find(&revisions); tx.Error != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(tx.Error))
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
 }
 
 func GetUint(value string) (uint64, error) {
@@ -282,12 +347,25 @@ func GetIntOr(value string, defaultValue int) (int, error) {
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// Params lists the missing or invalid params when err is a *template.ParamsError.
+	Params []template.ParamError `json:"params,omitempty"`
+	// Cost is the estimate that refused the query when err is a *services.CostError.
+	Cost *services.CostEstimate `json:"cost,omitempty"`
 }
 
 func NewErrorResponse(err error) *ErrorResponse {
-	return &ErrorResponse{
+	response := &ErrorResponse{
 		Error: err.Error(),
 	}
+	var paramsError *template.ParamsError
+	if errors.As(err, &paramsError) {
+		response.Params = paramsError.Errors
+	}
+	var costError *services.CostError
+	if errors.As(err, &costError) {
+		response.Cost = costError.Estimate
+	}
+	return response
 }
 
 func (controller *MainController) CreateSection(c *gin.Context) {
@@ -357,12 +435,27 @@ func (controller *MainController) CreateQuery(c *gin.Context) {
 		return
 	}
 
+	sql, args, err := controller.queryService.CompileQuery(request.ConnectionId, request.Query, request.Params, services.CompileOptions{
+		Declarations: request.ParamDeclarations,
+		Engine:       request.Engine,
+		Bind:         request.Bind,
+		IssueID:      issue.ID,
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
 	sqlQuery := models.SQLQuery{
 		ConnectionId: request.ConnectionId,
 		IssueID:      issue.ID,
 		SectionID:    section.ID,
 		Title:        request.Title,
 		Query:        request.Query,
+		Sql:          sql,
+		Status:       models.QueryStatusPending,
+		Bind:         request.Bind,
+		Engine:       request.Engine,
 	}
 
 	if request.Params != nil {
@@ -374,32 +467,51 @@ func (controller *MainController) CreateQuery(c *gin.Context) {
 		sqlQuery.Params = datatypes.JSON(paramsBytes)
 	}
 
+	if request.ParamDeclarations != nil {
+		declarationsBytes, err := jsoniter.Marshal(request.ParamDeclarations)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+			return
+		}
+		sqlQuery.ParamDeclarations = datatypes.JSON(declarationsBytes)
+	}
+
+	dependsOn, err := controller.queryDependencies(&sqlQuery)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
 	if err := controller.repository.CreateQuery(&sqlQuery); err != nil {
 		c.AbortWithStatusJSON(400, NewErrorResponse(err))
 		return
 	}
 
-	sql := controller.queryService.CompileSQL(request.Query, request.Params)
-	startTime := time.Now()
-	queryResult, err := controller.queryService.Query(c, request.ConnectionId, sql)
-	finishTime := time.Now()
-	if err != nil {
+	if err := controller.repository.SetDependencies(sqlQuery.ID, dependsOn); err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
 	}
 
-	resultBytes, err := jsoniter.Marshal(queryResult)
+	execution, err := controller.newExecution(&sqlQuery, sqlQuery.Params, sql)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
 		return
 	}
 
-	sqlQuery.Result = datatypes.JSON(resultBytes)
-	sqlQuery.Duration = finishTime.Sub(startTime).Milliseconds()
-	sqlQuery.Sql = sql
+	ctx := services.WithOwner(c, RequestOwner(c))
+	options := connection.QueryOptions{
+		MaxRows:     request.Limit,
+		Args:        args,
+		ConfirmCost: request.ConfirmCost,
+	}
 
-	if err := controller.repository.Save(&sqlQuery); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+	job, err := controller.dispatchExecution(ctx, &sqlQuery, execution, options, request.Async, nil)
+	if err != nil {
+		c.AbortWithStatusJSON(QueryErrorStatus(err, http.StatusInternalServerError), NewErrorResponse(err))
+		return
+	}
+	if job != nil {
+		c.JSON(http.StatusAccepted, job)
 		return
 	}
 
@@ -450,22 +562,42 @@ func (controller *MainController) ListQueries(c *gin.Context) {
 
 func NewQueryResponse(sqlQuery *models.SQLQuery) *QueryResponse {
 	return &QueryResponse{
-		ID:       sqlQuery.ID,
-		Query:    sqlQuery.Query,
-		Params:   sqlQuery.Params,
-		SQL:      sqlQuery.Sql,
-		Result:   sqlQuery.Result,
-		Duration: sqlQuery.Duration,
+		ID:         sqlQuery.ID,
+		Query:      sqlQuery.Query,
+		Params:     sqlQuery.Params,
+		SQL:        sqlQuery.Sql,
+		Result:     sqlQuery.Result,
+		Duration:   sqlQuery.Duration,
+		Status:     sqlQuery.Status,
+		Error:      sqlQuery.Error,
+		RowCount:   sqlQuery.RowCount,
+		StartedAt:  sqlQuery.StartedAt,
+		FinishedAt: sqlQuery.FinishedAt,
+
+		ParamDeclarations: sqlQuery.ParamDeclarations,
+		Bind:              sqlQuery.Bind,
+		Engine:            sqlQuery.Engine,
+		Plan:              sqlQuery.Plan,
 	}
 }
 
 type QueryResponse struct {
-	ID       uint64         `json:"id"`
-	Query    string         `json:"query"`
-	Params   datatypes.JSON `json:"params"`
-	SQL      string         `json:"sql"`
-	Result   datatypes.JSON `json:"result"`
-	Duration int64          `json:"duration"`
+	ID         uint64         `json:"id"`
+	Query      string         `json:"query"`
+	Params     datatypes.JSON `json:"params"`
+	SQL        string         `json:"sql"`
+	Result     datatypes.JSON `json:"result"`
+	Duration   int64          `json:"duration"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	RowCount   int            `json:"row_count"`
+	StartedAt  *time.Time     `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at"`
+
+	ParamDeclarations datatypes.JSON `json:"param_declarations"`
+	Bind              bool           `json:"bind"`
+	Engine            string         `json:"engine"`
+	Plan              datatypes.JSON `json:"plan"`
 }
 
 func (controller *MainController) ListSections(c *gin.Context) {
@@ -585,3 +717,47 @@ func (controller *MainController) DeleteQuery(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+func (controller *MainController) GetJob(c *gin.Context) {
+	job, err := controller.jobService.Get(c.Param("jobId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (controller *MainController) GetJobResult(c *gin.Context) {
+	job, err := controller.jobService.Get(c.Param("jobId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(err))
+		return
+	}
+
+	if job.Status != services.JobSucceeded {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "job is " + job.Status,
+			"job":   job,
+		})
+		return
+	}
+
+	execution, err := controller.repository.FindExecution(job.ExecutionID, &models.QueryExecution{})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewExecutionResponse(execution))
+}
+
+func (controller *MainController) CancelJob(c *gin.Context) {
+	job, err := controller.jobService.Cancel(c.Param("jobId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -1,7 +1,11 @@
 package controllers
 
 import (
+	"data-explorer/pkg/dataexplorer/connection"
 	"data-explorer/pkg/dataexplorer/services"
+	"data-explorer/pkg/dataexplorer/template"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -12,6 +16,36 @@ type QueryRequest struct {
 	Title        string            `json:"title"`
 	Query        string            `json:"query"`
 	Params       map[string]string `json:"params"`
+	CompileRequest
+	ConfirmCostRequest
+
+	// Limit caps the number of returned rows, the connection max_rows still applies.
+	Limit int `json:"limit"`
+	// Script runs every statement of the query in order and returns one result each.
+	Script bool `json:"script"`
+	// IssueId lets the query reference the saved queries of an issue.
+	IssueId uint64 `json:"issue_id"`
+}
+
+// CompileRequest holds how the query of a request is compiled, see QueryService.CompileQuery.
+type CompileRequest struct {
+	ParamDeclarations []template.ParamDeclaration `json:"param_declarations"`
+	// Bind passes param values as query arguments.
+	Bind bool `json:"bind"`
+	// Engine is template.EngineSimple, the default, or template.EngineGo.
+	Engine string `json:"engine"`
+}
+
+// ConfirmCostRequest is embedded by the requests that run queries.
+type ConfirmCostRequest struct {
+	// ConfirmCost runs queries even when they are estimated above the confirm_input_bytes of their connection.
+	ConfirmCost bool `json:"confirm_cost"`
+}
+
+type DiscoverParamsRequest struct {
+	Query             string                      `json:"query" binding:"required"`
+	Engine            string                      `json:"engine"`
+	ParamDeclarations []template.ParamDeclaration `json:"param_declarations"`
 }
 
 type QueryController struct {
@@ -33,19 +67,236 @@ func (controller *QueryController) Query(c *gin.Context) {
 		return
 	}
 
-	sql := controller.queryService.CompileSQL(request.Query, request.Params)
+	sql, args, err := controller.queryService.CompileQuery(request.ConnectionId, request.Query, request.Params, services.CompileOptions{
+		Declarations: request.ParamDeclarations,
+		Engine:       request.Engine,
+		Bind:         request.Bind,
+		IssueID:      request.IssueId,
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if request.Script {
+		controller.script(c, &request, sql, args)
+		return
+	}
 
-	result, err := controller.queryService.Query(c, request.ConnectionId, sql)
+	if format := c.Query("stream"); format != "" {
+		controller.stream(c, format, &request, sql, args)
+		return
+	}
+
+	result, err := controller.queryService.Query(services.WithOwner(c, RequestOwner(c)), request.ConnectionId, sql, connection.QueryOptions{
+		MaxRows:     request.Limit,
+		Args:        args,
+		ConfirmCost: request.ConfirmCost,
+	})
 
 	if err != nil {
+		c.AbortWithStatusJSON(QueryErrorStatus(err, http.StatusBadRequest), NewErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"query":  request.Query,
+		"params": request.Params,
+		"result": result,
+	})
+}
+
+// stream answers /api/query?stream=ndjson|json without buffering the rows, see StreamWriter.
+func (controller *QueryController) stream(c *gin.Context, format string, request *QueryRequest, sql string, args []interface{}) {
+	if format != StreamNDJSON && format != StreamJSON {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
+			"error": "stream must be ndjson or json",
 		})
 		return
 	}
+
+	writer := NewStreamWriter(c.Writer, format, map[string]interface{}{
+		"query":  request.Query,
+		"params": request.Params,
+	})
+
+	summary, err := controller.queryService.Stream(services.WithOwner(c, RequestOwner(c)), request.ConnectionId, sql, connection.QueryOptions{
+		MaxRows:     request.Limit,
+		Args:        args,
+		ConfirmCost: request.ConfirmCost,
+	}, writer)
+	if !writer.Started() {
+		c.AbortWithStatusJSON(QueryErrorStatus(err, http.StatusBadRequest), NewErrorResponse(err))
+		return
+	}
+
+	if err := writer.Finish(summary, err); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// DiscoverParams lists the params a query uses so that a form can be rendered before running it.
+func (controller *QueryController) DiscoverParams(c *gin.Context) {
+	var request DiscoverParamsRequest
+	if err := c.Bind(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if !template.IsEngine(request.Engine) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(fmt.Errorf("unknown template engine %s", request.Engine)))
+		return
+	}
+
+	params, err := template.DiscoverParams(request.Query, request.Engine, request.ParamDeclarations, controller.queryService.SnippetBody)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"params": params,
+	})
+}
+
+// Explain returns the plan of the compiled query without running it.
+func (controller *QueryController) Explain(c *gin.Context) {
+	var request QueryRequest
+	if err := c.Bind(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	sql, args, err := controller.queryService.CompileQuery(request.ConnectionId, request.Query, request.Params, services.CompileOptions{
+		Declarations: request.ParamDeclarations,
+		Engine:       request.Engine,
+		Bind:         request.Bind,
+		IssueID:      request.IssueId,
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	plan, err := controller.queryService.Explain(services.WithOwner(c, RequestOwner(c)), request.ConnectionId, sql, args)
+	if err != nil {
+		c.AbortWithStatusJSON(QueryErrorStatus(err, http.StatusBadRequest), NewErrorResponse(err))
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"query":  request.Query,
 		"params": request.Params,
-		"result": result,
+		"sql":    sql,
+		"plan":   plan,
+	})
+}
+
+// DryRun compiles the query and returns its estimated cost without running it.
+func (controller *QueryController) DryRun(c *gin.Context) {
+	var request QueryRequest
+	if err := c.Bind(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	sql, args, err := controller.queryService.CompileQuery(request.ConnectionId, request.Query, request.Params, services.CompileOptions{
+		Declarations: request.ParamDeclarations,
+		Engine:       request.Engine,
+		Bind:         request.Bind,
+		IssueID:      request.IssueId,
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+	if len(args) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("bind params cannot be estimated")))
+		return
+	}
+
+	estimate, err := controller.queryService.EstimateCost(c, request.ConnectionId, sql, request.Script)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":  request.Query,
+		"params": request.Params,
+		"sql":    sql,
+		"cost":   estimate,
 	})
 }
+
+// script answers /api/query with "script": true, the results of the statements run
+// are returned even when one of them fails.
+func (controller *QueryController) script(c *gin.Context, request *QueryRequest, sql string, args []interface{}) {
+	if len(args) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("bind params are not supported in scripts")))
+		return
+	}
+	if c.Query("stream") != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(errors.New("scripts cannot be streamed")))
+		return
+	}
+
+	results, err := controller.queryService.Script(services.WithOwner(c, RequestOwner(c)), request.ConnectionId, sql, connection.QueryOptions{
+		MaxRows:     request.Limit,
+		ConfirmCost: request.ConfirmCost,
+	})
+	if err != nil {
+		c.AbortWithStatusJSON(QueryErrorStatus(err, http.StatusBadRequest), gin.H{
+			"error":   err.Error(),
+			"results": results,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":   request.Query,
+		"params":  request.Params,
+		"results": results,
+	})
+}
+
+func (controller *QueryController) ListRunningQueries(c *gin.Context) {
+	c.JSON(http.StatusOK, controller.queryService.RunningQueries.List())
+}
+
+func (controller *QueryController) CancelRunningQuery(c *gin.Context) {
+	if err := controller.queryService.RunningQueries.Cancel(c.Param("runningQueryId")); err != nil {
+		if errors.Is(err, services.ErrRunningQueryNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(err))
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// QueryErrorStatus maps errors of QueryService and JobService to a status code, falling back to status.
+func QueryErrorStatus(err error, status int) int {
+	if errors.Is(err, services.ErrQueueFull) || errors.Is(err, services.ErrJobQueueFull) {
+		return http.StatusTooManyRequests
+	}
+	var costError *services.CostError
+	if errors.As(err, &costError) {
+		return http.StatusConflict
+	}
+	var statementError *connection.StatementNotAllowedError
+	if errors.As(err, &statementError) {
+		return http.StatusForbidden
+	}
+	return status
+}
+
+// RequestOwner identifies who sent the request, from the X-User header set by an
+// authenticating proxy or else the client IP.
+func RequestOwner(c *gin.Context) string {
+	if user := c.GetHeader("X-User"); user != "" {
+		return user
+	}
+	return c.ClientIP()
+}
@@ -0,0 +1,556 @@
+package controllers
+
+import (
+	"context"
+	"data-explorer/pkg/dataexplorer/connection"
+	"data-explorer/pkg/dataexplorer/models"
+	"data-explorer/pkg/dataexplorer/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/samber/lo"
+	"gorm.io/datatypes"
+)
+
+type RunQueryRequest struct {
+	// Params override the stored params of the query for this run only.
+	Params map[string]string `json:"params"`
+	Limit  int               `json:"limit"`
+	Async  bool              `json:"async"`
+	// Downstream runs the queries that reference the result of this one again afterwards.
+	Downstream bool `json:"downstream"`
+	ConfirmCostRequest
+}
+
+type ExecutionResponse struct {
+	ID         uint64         `json:"id"`
+	QueryID    uint64         `json:"query_id"`
+	Params     datatypes.JSON `json:"params"`
+	SQL        string         `json:"sql"`
+	Result     datatypes.JSON `json:"result,omitempty"`
+	Duration   int64          `json:"duration"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	RowCount   int            `json:"row_count"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at"`
+	// Downstream are the executions of the queries run again after this one.
+	Downstream []*ExecutionResponse `json:"downstream,omitempty"`
+}
+
+func NewExecutionResponse(execution *models.QueryExecution) *ExecutionResponse {
+	return &ExecutionResponse{
+		ID:         execution.ID,
+		QueryID:    execution.QueryID,
+		Params:     execution.Params,
+		SQL:        execution.Sql,
+		Result:     execution.Result,
+		Duration:   execution.Duration,
+		Status:     execution.Status,
+		Error:      execution.Error,
+		RowCount:   execution.RowCount,
+		CreatedAt:  execution.CreatedAt,
+		StartedAt:  execution.StartedAt,
+		FinishedAt: execution.FinishedAt,
+	}
+}
+
+func (controller *MainController) RunQuery(c *gin.Context) {
+	queryId, err := GetUint(c.Param("queryId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	var request RunQueryRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.Bind(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+			return
+		}
+	}
+
+	sqlQuery, err := controller.repository.FindQuery(queryId, &models.SQLQuery{})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(err))
+		return
+	}
+
+	controller.run(c, sqlQuery, &request)
+}
+
+// run executes a saved query with request and responds with the execution, or with the job when async.
+func (controller *MainController) run(c *gin.Context, sqlQuery *models.SQLQuery, request *RunQueryRequest) {
+	params, sql, args, err := controller.compileRun(sqlQuery, request)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	execution, err := controller.newExecution(sqlQuery, params, sql)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	ctx := services.WithOwner(c, RequestOwner(c))
+	options := connection.QueryOptions{
+		MaxRows:     request.Limit,
+		Args:        args,
+		ConfirmCost: request.ConfirmCost,
+	}
+
+	var downstream []*models.QueryExecution
+	var then func(ctx context.Context) error
+	if request.Downstream {
+		then = func(ctx context.Context) error {
+			var err error
+			downstream, err = controller.runDownstream(ctx, sqlQuery.ID, request.ConfirmCost)
+			return err
+		}
+	}
+
+	job, err := controller.dispatchExecution(ctx, sqlQuery, execution, options, request.Async, then)
+	if err != nil {
+		c.AbortWithStatusJSON(QueryErrorStatus(err, http.StatusInternalServerError), NewErrorResponse(err))
+		return
+	}
+	if job != nil {
+		c.JSON(http.StatusAccepted, job)
+		return
+	}
+
+	response := NewExecutionResponse(execution)
+	response.Downstream = lo.Map(downstream, func(execution *models.QueryExecution, index int) *ExecutionResponse {
+		return NewExecutionResponse(execution)
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+// ExplainQuery gets the plan of a saved query compiled with its params and stores it on the query.
+func (controller *MainController) ExplainQuery(c *gin.Context) {
+	queryId, err := GetUint(c.Param("queryId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	var request RunQueryRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.Bind(&request); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+			return
+		}
+	}
+
+	sqlQuery, err := controller.repository.FindQuery(queryId, &models.SQLQuery{})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, NewErrorResponse(err))
+		return
+	}
+
+	_, sql, args, err := controller.compileRun(sqlQuery, &request)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	plan, err := controller.queryService.Explain(services.WithOwner(c, RequestOwner(c)), sqlQuery.ConnectionId, sql, args)
+	if err != nil {
+		c.AbortWithStatusJSON(QueryErrorStatus(err, http.StatusBadRequest), NewErrorResponse(err))
+		return
+	}
+
+	if sqlQuery.Plan, err = jsoniter.Marshal(plan); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+	if err := controller.repository.UpdateQueryColumns(sqlQuery, "plan"); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewQueryResponse(sqlQuery))
+}
+
+// compileRun compiles the saved query with its stored params, overridden by the params of request.
+func (controller *MainController) compileRun(
+	sqlQuery *models.SQLQuery,
+	request *RunQueryRequest,
+) (datatypes.JSON, string, []interface{}, error) {
+	params := map[string]string{}
+	if len(sqlQuery.Params) > 0 {
+		if err := jsoniter.Unmarshal(sqlQuery.Params, &params); err != nil {
+			return nil, "", nil, err
+		}
+	}
+	for key, value := range request.Params {
+		params[key] = value
+	}
+
+	paramsBytes, err := jsoniter.Marshal(params)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	declarations, err := sqlQuery.Declarations()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	sql, args, err := controller.queryService.CompileQuery(sqlQuery.ConnectionId, sqlQuery.Query, params, services.CompileOptions{
+		Declarations: declarations,
+		Engine:       sqlQuery.Engine,
+		Bind:         sqlQuery.Bind,
+		IssueID:      sqlQuery.IssueID,
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return datatypes.JSON(paramsBytes), sql, args, nil
+}
+
+// runDownstream runs every query depending on the query again, directly or not, each
+// after the queries it depends on. It stops at the first failure.
+func (controller *MainController) runDownstream(ctx context.Context, queryId uint64, confirmCost bool) ([]*models.QueryExecution, error) {
+	order, err := controller.downstreamOrder(queryId)
+	if err != nil {
+		return nil, err
+	}
+
+	var executions []*models.QueryExecution
+	for _, downstreamId := range order {
+		sqlQuery, err := controller.repository.FindQuery(downstreamId, &models.SQLQuery{})
+		if err != nil {
+			return executions, err
+		}
+
+		params, sql, args, err := controller.compileRun(sqlQuery, &RunQueryRequest{})
+		if err != nil {
+			return executions, fmt.Errorf("downstream query %d: %w", downstreamId, err)
+		}
+
+		execution, err := controller.newExecution(sqlQuery, params, sql)
+		if err != nil {
+			return executions, err
+		}
+		executions = append(executions, execution)
+
+		if err := controller.runExecution(ctx, sqlQuery, execution, connection.QueryOptions{Args: args, ConfirmCost: confirmCost}); err != nil {
+			return executions, fmt.Errorf("downstream query %d: %w", downstreamId, err)
+		}
+	}
+	return executions, nil
+}
+
+// downstreamOrder sorts the queries depending on queryId, directly or not, so that
+// every query comes after the queries it depends on.
+func (controller *MainController) downstreamOrder(queryId uint64) ([]uint64, error) {
+	var dependencies []models.QueryDependency
+	reached := map[uint64]bool{queryId: true}
+	for frontier := []uint64{queryId}; len(frontier) > 0; {
+		found, err := controller.repository.FindDependencies(frontier, true)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = nil
+		for _, dependency := range found {
+			if dependency.QueryID == queryId {
+				continue
+			}
+			dependencies = append(dependencies, dependency)
+			if !reached[dependency.QueryID] {
+				reached[dependency.QueryID] = true
+				frontier = append(frontier, dependency.QueryID)
+			}
+		}
+	}
+
+	waiting := map[uint64]int{}
+	for _, dependency := range dependencies {
+		waiting[dependency.QueryID]++
+	}
+
+	var order []uint64
+	for ready := []uint64{queryId}; len(ready) > 0; {
+		done := ready[0]
+		ready = ready[1:]
+		for _, dependency := range dependencies {
+			if dependency.DependsOnID != done {
+				continue
+			}
+			waiting[dependency.QueryID]--
+			if waiting[dependency.QueryID] == 0 {
+				order = append(order, dependency.QueryID)
+				ready = append(ready, dependency.QueryID)
+			}
+		}
+	}
+
+	if len(order) < len(reached)-1 {
+		return nil, fmt.Errorf("queries depending on query %d reference each other in a cycle", queryId)
+	}
+	return order, nil
+}
+
+// queryDependencies returns the saved queries whose results the query references.
+// A saved query cannot reference a query that depends on it, directly or not.
+func (controller *MainController) queryDependencies(sqlQuery *models.SQLQuery) ([]uint64, error) {
+	dependsOn, err := controller.queryService.QueryReferences(sqlQuery.Query)
+	if err != nil {
+		return nil, err
+	}
+	if lo.Contains(dependsOn, sqlQuery.ID) {
+		return nil, fmt.Errorf("query %d cannot reference its own result", sqlQuery.ID)
+	}
+	if sqlQuery.ID == 0 {
+		return dependsOn, nil
+	}
+
+	reached := map[uint64]bool{}
+	for frontier := dependsOn; len(frontier) > 0; {
+		found, err := controller.repository.FindDependencies(frontier, false)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = nil
+		for _, dependency := range found {
+			if dependency.DependsOnID == sqlQuery.ID {
+				return nil, fmt.Errorf("query %d would depend on itself through query %d", sqlQuery.ID, dependency.QueryID)
+			}
+			if !reached[dependency.DependsOnID] {
+				reached[dependency.DependsOnID] = true
+				frontier = append(frontier, dependency.DependsOnID)
+			}
+		}
+	}
+	return dependsOn, nil
+}
+
+func (controller *MainController) ListDependencies(c *gin.Context) {
+	queryId, err := GetUint(c.Param("queryId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	dependsOn, err := controller.repository.FindDependencies([]uint64{queryId}, false)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	dependents, err := controller.repository.FindDependencies([]uint64{queryId}, true)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"depends_on": lo.Map(dependsOn, func(dependency models.QueryDependency, index int) uint64 {
+			return dependency.DependsOnID
+		}),
+		"dependents": lo.Map(dependents, func(dependency models.QueryDependency, index int) uint64 {
+			return dependency.QueryID
+		}),
+	})
+}
+
+func (controller *MainController) ListExecutions(c *gin.Context) {
+	page, err := GetIntOr(c.Query("page"), 1)
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+	limit, err := GetIntOr(c.Query("page_size"), 20)
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	offset := (page - 1) * limit
+
+	queryId, err := GetUint(c.Param("queryId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	var executions []models.QueryExecution
+
+	if tx := controller.repository.DB.
+		Limit(limit).Offset(offset).
+		Where(&models.QueryExecution{QueryID: queryId}).
+		Order("id DESC").
+		Find(&executions); tx.Error != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(tx.Error))
+		return
+	}
+
+	response := lo.Map(executions, func(item models.QueryExecution, index int) *ExecutionResponse {
+		return NewExecutionResponse(&item)
+	})
+	c.JSON(200, response)
+}
+
+func (controller *MainController) GetExecution(c *gin.Context) {
+	queryId, err := GetUint(c.Param("queryId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	executionId, err := GetUint(c.Param("executionId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	execution, err := controller.repository.FindExecution(executionId, &models.QueryExecution{QueryID: queryId})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, NewExecutionResponse(execution))
+}
+
+// newExecution records a pending run of the saved query.
+func (controller *MainController) newExecution(
+	sqlQuery *models.SQLQuery,
+	params datatypes.JSON,
+	sql string,
+) (*models.QueryExecution, error) {
+	execution := models.QueryExecution{
+		QueryID:      sqlQuery.ID,
+		ConnectionId: sqlQuery.ConnectionId,
+		Params:       params,
+		Sql:          sql,
+		Status:       models.QueryStatusPending,
+	}
+
+	if err := controller.repository.CreateExecution(&execution); err != nil {
+		return nil, err
+	}
+
+	return &execution, nil
+}
+
+// dispatchExecution runs the execution right away, or submits it as a job when async is set.
+// then, when not nil, runs once the execution succeeded, as part of the job.
+func (controller *MainController) dispatchExecution(
+	ctx context.Context,
+	sqlQuery *models.SQLQuery,
+	execution *models.QueryExecution,
+	options connection.QueryOptions,
+	async bool,
+	then func(ctx context.Context) error,
+) (*services.Job, error) {
+	run := func(ctx context.Context) error {
+		if err := controller.runExecution(ctx, sqlQuery, execution, options); err != nil {
+			return err
+		}
+		if then != nil {
+			return then(ctx)
+		}
+		return nil
+	}
+
+	if !async {
+		return nil, run(ctx)
+	}
+
+	job, err := controller.jobService.Submit(ctx, sqlQuery.ID, execution.ID, sqlQuery.ConnectionId, func(ctx context.Context, job *services.Job) error {
+		options.Progress = job.SetRowsRead
+		options.Queued = job.SetQueuePosition
+		return run(ctx)
+	})
+	if err != nil {
+		if saveErr := controller.recordFailure(sqlQuery, execution, err); saveErr != nil {
+			return nil, errors.Join(err, saveErr)
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// runExecution executes the compiled SQL of the execution and stores the outcome with
+// it and with its query, including the error when the execution fails.
+func (controller *MainController) runExecution(
+	ctx context.Context,
+	sqlQuery *models.SQLQuery,
+	execution *models.QueryExecution,
+	options connection.QueryOptions,
+) error {
+	// Jobs cancelled while queued never start.
+	if err := ctx.Err(); err != nil {
+		if saveErr := controller.recordFailure(sqlQuery, execution, err); saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
+		return err
+	}
+
+	startTime := time.Now()
+	execution.Status = models.QueryStatusRunning
+	execution.StartedAt = &startTime
+	if err := controller.saveExecution(sqlQuery, execution); err != nil {
+		return err
+	}
+
+	queryResult, err := controller.queryService.Query(ctx, execution.ConnectionId, execution.Sql, options)
+	finishTime := time.Now()
+	execution.FinishedAt = &finishTime
+	execution.Duration = finishTime.Sub(startTime).Milliseconds()
+	if err != nil {
+		if saveErr := controller.recordFailure(sqlQuery, execution, err); saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
+		return err
+	}
+
+	resultBytes, err := jsoniter.Marshal(queryResult)
+	if err != nil {
+		if saveErr := controller.recordFailure(sqlQuery, execution, err); saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
+		return err
+	}
+
+	execution.Result = datatypes.JSON(resultBytes)
+	execution.RowCount = queryResult.RowCount
+	execution.Status = models.QueryStatusSucceeded
+
+	return controller.saveExecution(sqlQuery, execution)
+}
+
+// recordFailure marks the execution as failed, or cancelled when err comes from a cancelled context.
+func (controller *MainController) recordFailure(sqlQuery *models.SQLQuery, execution *models.QueryExecution, err error) error {
+	if execution.FinishedAt == nil {
+		finishTime := time.Now()
+		execution.FinishedAt = &finishTime
+	}
+	execution.Status = models.QueryStatusFailed
+	if errors.Is(err, context.Canceled) {
+		execution.Status = models.QueryStatusCancelled
+	}
+	execution.Error = err.Error()
+
+	return controller.saveExecution(sqlQuery, execution)
+}
+
+// saveExecution stores the execution and mirrors it on the query as its latest run.
+func (controller *MainController) saveExecution(sqlQuery *models.SQLQuery, execution *models.QueryExecution) error {
+	if err := controller.repository.UpdateExecution(execution); err != nil {
+		return err
+	}
+	execution.ApplyTo(sqlQuery)
+	return controller.repository.UpdateQueryColumns(sqlQuery, models.ExecutionColumns...)
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"data-explorer/pkg/dataexplorer/models"
+	"data-explorer/pkg/dataexplorer/repositories"
+	"data-explorer/pkg/dataexplorer/services"
+	"data-explorer/pkg/dataexplorer/template"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateSnippetRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Body        string `json:"body" binding:"required"`
+}
+
+type PreviewSnippetsRequest struct {
+	Query string `json:"query" binding:"required"`
+}
+
+type SnippetController struct {
+	repository   *repositories.Repository
+	queryService *services.QueryService
+}
+
+func NewSnippetController(repository *repositories.Repository, queryService *services.QueryService) *SnippetController {
+	return &SnippetController{
+		repository:   repository,
+		queryService: queryService,
+	}
+}
+
+func (controller *SnippetController) CreateSnippet(c *gin.Context) {
+	var request CreateSnippetRequest
+	if err := c.Bind(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	snippet := models.Snippet{
+		Name:        request.Name,
+		Description: request.Description,
+		Body:        request.Body,
+	}
+
+	if err := controller.checkSnippet(&snippet); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if err := controller.repository.CreateSnippet(&snippet); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, snippet)
+}
+
+func (controller *SnippetController) ListSnippets(c *gin.Context) {
+	page, err := GetIntOr(c.Query("page"), 1)
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+	limit, err := GetIntOr(c.Query("page_size"), 20)
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	offset := (page - 1) * limit
+
+	var snippets []models.Snippet
+	if tx := controller.repository.DB.Limit(limit).Offset(offset).Order("name").Find(&snippets); tx.Error != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(tx.Error))
+		return
+	}
+
+	c.JSON(http.StatusOK, snippets)
+}
+
+func (controller *SnippetController) GetSnippet(c *gin.Context) {
+	snippet, ok := controller.findSnippet(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, snippet)
+}
+
+func (controller *SnippetController) PatchSnippet(c *gin.Context) {
+	snippet, ok := controller.findSnippet(c)
+	if !ok {
+		return
+	}
+
+	var request repositories.PatchSnippetRequest
+	if err := c.Bind(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	previousName := snippet.Name
+	if request.Name.HasValue() && request.Name.Value != nil {
+		snippet.Name = *request.Name.Value
+	}
+	if request.Description.HasValue() && request.Description.Value != nil {
+		snippet.Description = *request.Description.Value
+	}
+	if request.Body.HasValue() && request.Body.Value != nil {
+		snippet.Body = *request.Body.Value
+	}
+
+	if err := controller.checkSnippet(snippet); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	if err := controller.repository.SaveSnippet(snippet, previousName); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, repositories.ErrSnippetInUse) {
+			status = http.StatusConflict
+		}
+		c.AbortWithStatusJSON(status, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, snippet)
+}
+
+func (controller *SnippetController) DeleteSnippet(c *gin.Context) {
+	snippetId, err := GetUint(c.Param("snippetId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return
+	}
+
+	if err := controller.repository.DeleteSnippet(snippetId); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, repositories.ErrSnippetInUse):
+			status = http.StatusConflict
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			status = http.StatusNotFound
+		}
+		c.AbortWithStatusJSON(status, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// PreviewSnippets returns the query with its includes expanded, params are left as they are.
+func (controller *SnippetController) PreviewSnippets(c *gin.Context) {
+	var request PreviewSnippetsRequest
+	if err := c.Bind(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	sql, err := controller.queryService.ExpandIncludes(request.Query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query": request.Query,
+		"sql":   sql,
+	})
+}
+
+func (controller *SnippetController) findSnippet(c *gin.Context) (*models.Snippet, bool) {
+	snippetId, err := GetUint(c.Param("snippetId"))
+	if err != nil {
+		c.AbortWithStatusJSON(400, NewErrorResponse(err))
+		return nil, false
+	}
+
+	snippet, err := controller.repository.FindSnippet(snippetId)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+		}
+		c.AbortWithStatusJSON(status, NewErrorResponse(err))
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// checkSnippet makes sure the snippet has a usable name and expands as if it was
+// already saved, so that include cycles are refused before they are stored.
+func (controller *SnippetController) checkSnippet(snippet *models.Snippet) error {
+	if !template.IsSnippetName(snippet.Name) {
+		return fmt.Errorf("snippet name %q may only contain letters, digits, _, . and -", snippet.Name)
+	}
+
+	_, err := template.ExpandIncludes("${include:"+snippet.Name+"}", func(name string) (string, error) {
+		if name == snippet.Name {
+			return snippet.Body, nil
+		}
+		included, err := controller.repository.FindSnippetByName(name)
+		if err != nil {
+			return "", err
+		}
+		return included.Body, nil
+	})
+	return err
+}
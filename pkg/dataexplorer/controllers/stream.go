@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"data-explorer/pkg/dataexplorer/connection"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	StreamNDJSON = "ndjson"
+	StreamJSON   = "json"
+)
+
+// rows are flushed to the client in batches to keep the number of writes down.
+const streamFlushEvery = 1000
+
+// StreamWriter writes query results to the response as they are scanned.
+//
+// The ndjson format writes the columns as the first line, then one JSON array per row
+// and finally the result summary.
+// The json format produces the same document as the buffered /api/query response,
+// written incrementally. In both formats an error that happens after the first byte
+// has been sent is reported in an "error" field at the end.
+type StreamWriter struct {
+	writer  gin.ResponseWriter
+	format  string
+	prefix  map[string]interface{}
+	started bool
+	rows    int
+}
+
+func NewStreamWriter(writer gin.ResponseWriter, format string, prefix map[string]interface{}) *StreamWriter {
+	return &StreamWriter{
+		writer: writer,
+		format: format,
+		prefix: prefix,
+	}
+}
+
+// Started reports whether anything was written, after which a status code can no longer be sent.
+func (s *StreamWriter) Started() bool {
+	return s.started
+}
+
+func (s *StreamWriter) Columns(columnNames []string, columnTypes []string) error {
+	s.started = true
+
+	columns := map[string]interface{}{
+		"column_names": columnNames,
+		"column_types": columnTypes,
+	}
+
+	if s.format == StreamNDJSON {
+		s.writer.Header().Set("Content-Type", "application/x-ndjson")
+		s.writer.WriteHeader(http.StatusOK)
+		if err := s.writeLine(columns); err != nil {
+			return err
+		}
+	} else {
+		s.writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		s.writer.WriteHeader(http.StatusOK)
+		if _, err := s.writer.WriteString("{"); err != nil {
+			return err
+		}
+		for key, value := range s.prefix {
+			if err := s.writeField(key, value); err != nil {
+				return err
+			}
+			if _, err := s.writer.WriteString(","); err != nil {
+				return err
+			}
+		}
+		if _, err := s.writer.WriteString(`"result":{`); err != nil {
+			return err
+		}
+		if err := s.writeField("column_names", columnNames); err != nil {
+			return err
+		}
+		if _, err := s.writer.WriteString(","); err != nil {
+			return err
+		}
+		if err := s.writeField("column_types", columnTypes); err != nil {
+			return err
+		}
+		if _, err := s.writer.WriteString(`,"records":[`); err != nil {
+			return err
+		}
+	}
+
+	s.writer.Flush()
+	return nil
+}
+
+func (s *StreamWriter) Row(record []interface{}) error {
+	if s.format == StreamNDJSON {
+		if err := s.writeLine(record); err != nil {
+			return err
+		}
+	} else {
+		if s.rows > 0 {
+			if _, err := s.writer.WriteString(","); err != nil {
+				return err
+			}
+		}
+		if err := s.writeValue(record); err != nil {
+			return err
+		}
+	}
+
+	s.rows++
+	if s.rows%streamFlushEvery == 0 {
+		s.writer.Flush()
+	}
+	return nil
+}
+
+// Finish terminates the document with the result summary, or with err if the query failed mid-stream.
+func (s *StreamWriter) Finish(summary *connection.ResultSummary, err error) error {
+	if s.format == StreamNDJSON {
+		if err != nil {
+			if writeErr := s.writeLine(gin.H{"error": err.Error()}); writeErr != nil {
+				return writeErr
+			}
+		} else if writeErr := s.writeLine(summary); writeErr != nil {
+			return writeErr
+		}
+	} else {
+		if _, writeErr := s.writer.WriteString("]"); writeErr != nil {
+			return writeErr
+		}
+		if summary != nil {
+			for _, field := range []struct {
+				key   string
+				value interface{}
+			}{
+				{"row_count", summary.RowCount},
+				{"truncated", summary.Truncated},
+				{"limit", summary.Limit},
+			} {
+				if _, writeErr := s.writer.WriteString(","); writeErr != nil {
+					return writeErr
+				}
+				if writeErr := s.writeField(field.key, field.value); writeErr != nil {
+					return writeErr
+				}
+			}
+		}
+		if _, writeErr := s.writer.WriteString("}"); writeErr != nil {
+			return writeErr
+		}
+		if err != nil {
+			if _, writeErr := s.writer.WriteString(","); writeErr != nil {
+				return writeErr
+			}
+			if writeErr := s.writeField("error", err.Error()); writeErr != nil {
+				return writeErr
+			}
+		}
+		if _, writeErr := s.writer.WriteString("}"); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	s.writer.Flush()
+	return nil
+}
+
+func (s *StreamWriter) writeLine(value interface{}) error {
+	if err := s.writeValue(value); err != nil {
+		return err
+	}
+	_, err := s.writer.WriteString("\n")
+	return err
+}
+
+func (s *StreamWriter) writeField(key string, value interface{}) error {
+	if err := s.writeValue(key); err != nil {
+		return err
+	}
+	if _, err := s.writer.WriteString(":"); err != nil {
+		return err
+	}
+	return s.writeValue(value)
+}
+
+func (s *StreamWriter) writeValue(value interface{}) error {
+	bytes, err := jsoniter.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(bytes)
+	return err
+}
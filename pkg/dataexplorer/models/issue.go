@@ -1,8 +1,10 @@
 package models
 
 import (
+	"data-explorer/pkg/dataexplorer/template"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"gorm.io/datatypes"
 )
 
@@ -45,4 +47,39 @@ type SQLQuery struct {
 	Sql          string         `json:"sql" gorm:"type:text"`
 	Result       datatypes.JSON `json:"result"`
 	Duration     int64          `json:"duration"`
+
+	Status     string     `json:"status"`
+	Error      string     `json:"error" gorm:"type:text"`
+	RowCount   int        `json:"row_count"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	// ParamDeclarations holds the []template.ParamDeclaration the params are validated against.
+	ParamDeclarations datatypes.JSON `json:"param_declarations"`
+	// Bind passes param values as query arguments instead of substituting them.
+	Bind bool `json:"bind"`
+	// Engine is the template engine the query is written for, empty for template.EngineSimple.
+	Engine string `json:"engine"`
+	// Plan is the last connection.Plan stored with POST /api/queries/:queryId/explain.
+	Plan datatypes.JSON `json:"plan"`
+}
+
+const (
+	QueryStatusPending   = "pending"
+	QueryStatusRunning   = "running"
+	QueryStatusSucceeded = "succeeded"
+	QueryStatusFailed    = "failed"
+	QueryStatusCancelled = "cancelled"
+)
+
+// Declarations decodes the ParamDeclarations of the query.
+func (query *SQLQuery) Declarations() ([]template.ParamDeclaration, error) {
+	var declarations []template.ParamDeclaration
+	if len(query.ParamDeclarations) == 0 {
+		return declarations, nil
+	}
+	if err := jsoniter.Unmarshal(query.ParamDeclarations, &declarations); err != nil {
+		return nil, err
+	}
+	return declarations, nil
 }
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// QueryExecution is one run of a SQLQuery, the query itself keeps the outcome of the latest run.
+type QueryExecution struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	QueryID      uint64         `json:"query_id" gorm:"index"`
+	ConnectionId string         `json:"connection_id"`
+	Params       datatypes.JSON `json:"params"`
+	Sql          string         `json:"sql" gorm:"type:text"`
+	Result       datatypes.JSON `json:"result"`
+	Duration     int64          `json:"duration"`
+
+	Status     string     `json:"status"`
+	Error      string     `json:"error" gorm:"type:text"`
+	RowCount   int        `json:"row_count"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// ExecutionColumns record the outcome of a run, on QueryExecution and on the SQLQuery
+// it is applied to with ApplyTo.
+var ExecutionColumns = []string{"sql", "result", "duration", "status", "error", "row_count", "started_at", "finished_at"}
+
+// ApplyTo copies the outcome of the execution to the query it belongs to.
+func (execution *QueryExecution) ApplyTo(query *SQLQuery) {
+	query.Sql = execution.Sql
+	query.Result = execution.Result
+	query.Duration = execution.Duration
+	query.Status = execution.Status
+	query.Error = execution.Error
+	query.RowCount = execution.RowCount
+	query.StartedAt = execution.StartedAt
+	query.FinishedAt = execution.FinishedAt
+}
+
+// QueryRevision keeps the definition a SQLQuery had before it was edited.
+type QueryRevision struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	QueryID      uint64         `json:"query_id" gorm:"index"`
+	ConnectionId string         `json:"connection_id"`
+	Query        string         `json:"query" gorm:"type:text"`
+	Params       datatypes.JSON `json:"params"`
+
+	ParamDeclarations datatypes.JSON `json:"param_declarations"`
+	Bind              bool           `json:"bind"`
+	Engine            string         `json:"engine"`
+}
+
+// QueryDependency records that QueryID references the result of DependsOnID.
+type QueryDependency struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	QueryID     uint64 `json:"query_id" gorm:"uniqueIndex:idx_query_dependency"`
+	DependsOnID uint64 `json:"depends_on_id" gorm:"uniqueIndex:idx_query_dependency;index"`
+}
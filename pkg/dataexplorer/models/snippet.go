@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Snippet is a reusable piece of SQL that queries include with ${include:name}.
+type Snippet struct {
+	ID        uint64    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Name        string `json:"name" gorm:"uniqueIndex"`
+	Description string `json:"description"`
+	Body        string `json:"body" gorm:"type:text"`
+}
@@ -2,9 +2,16 @@ package repositories
 
 import (
 	"data-explorer/pkg/dataexplorer/models"
+	"data-explorer/pkg/dataexplorer/template"
 	"data-explorer/pkg/dataexplorer/types"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 
+	jsoniter "github.com/json-iterator/go"
+	"github.com/samber/lo"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -18,6 +25,11 @@ func NewRepository(db *gorm.DB) *Repository {
 	}
 }
 
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrSnippetInUse = errors.New("snippet is in use")
+)
+
 type PatchIssueRequest struct {
 	Title       types.Optional[string] `json:"title"`
 	Description types.Optional[string] `json:"description"`
@@ -30,7 +42,24 @@ type PatchSectionRequest struct {
 }
 
 type PatchQueryRequest struct {
-	Title types.Optional[string] `json:"title"`
+	Title        types.Optional[string]            `json:"title"`
+	Query        types.Optional[string]            `json:"query"`
+	Params       types.Optional[map[string]string] `json:"params"`
+	ConnectionId types.Optional[string]            `json:"connection_id"`
+
+	ParamDeclarations types.Optional[[]template.ParamDeclaration] `json:"param_declarations"`
+	Bind              types.Optional[bool]                        `json:"bind"`
+	Engine            types.Optional[string]                      `json:"engine"`
+	// Run executes the query again once patched.
+	Run         bool `json:"run"`
+	Async       bool `json:"async"`
+	ConfirmCost bool `json:"confirm_cost"`
+}
+
+type PatchSnippetRequest struct {
+	Name        types.Optional[string] `json:"name"`
+	Description types.Optional[string] `json:"description"`
+	Body        types.Optional[string] `json:"body"`
 }
 
 func (r *Repository) FindIssueByID(issueId uint64) (*models.Issue, error) {
@@ -76,13 +105,121 @@ func (r *Repository) PatchSection(section *models.Section, request PatchSectionR
 	return r.DB.Model(&section).Updates(attributes).Error
 }
 
-func (r *Repository) PatchQuery(query *models.SQLQuery, request PatchQueryRequest) error {
+// PatchQuery updates the query, keeping its previous definition as a revision
+// when the SQL text, params or connection change. The changed definition is
+// compiled first, a patch that does not compile is refused and the stored SQL is
+// replaced by the compiled one. dependsOn replaces the dependencies of the query
+// when the patch sets its text.
+func (r *Repository) PatchQuery(
+	query *models.SQLQuery,
+	request PatchQueryRequest,
+	compile func(patched *models.SQLQuery) (string, error),
+	dependsOn []uint64,
+) error {
 	attributes := map[string]interface{}{}
+	patched := *query
 	if request.Title.HasValue() {
 		attributes["title"] = request.Title.Value
 	}
 
-	return r.DB.Model(&query).Updates(attributes).Error
+	revised := false
+	if request.Query.HasValue() {
+		if request.Query.Value == nil || *request.Query.Value == "" {
+			return fmt.Errorf("%w: query must not be empty", ErrInvalidPatch)
+		}
+		attributes["query"] = *request.Query.Value
+		patched.Query = *request.Query.Value
+		revised = revised || *request.Query.Value != query.Query
+	}
+	if request.ConnectionId.HasValue() {
+		if request.ConnectionId.Value == nil || *request.ConnectionId.Value == "" {
+			return fmt.Errorf("%w: connection_id must not be empty", ErrInvalidPatch)
+		}
+		attributes["connection_id"] = *request.ConnectionId.Value
+		patched.ConnectionId = *request.ConnectionId.Value
+		revised = revised || *request.ConnectionId.Value != query.ConnectionId
+	}
+	if request.Params.HasValue() {
+		var params datatypes.JSON
+		if request.Params.Value != nil {
+			paramsBytes, err := jsoniter.Marshal(request.Params.Value)
+			if err != nil {
+				return err
+			}
+			params = datatypes.JSON(paramsBytes)
+		}
+		attributes["params"] = params
+		patched.Params = params
+		revised = revised || string(params) != string(query.Params)
+	}
+	if request.ParamDeclarations.HasValue() {
+		var declarations datatypes.JSON
+		if request.ParamDeclarations.Value != nil {
+			if err := template.ValidateDeclarations(*request.ParamDeclarations.Value); err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+			}
+			declarationsBytes, err := jsoniter.Marshal(request.ParamDeclarations.Value)
+			if err != nil {
+				return err
+			}
+			declarations = datatypes.JSON(declarationsBytes)
+		}
+		attributes["param_declarations"] = declarations
+		patched.ParamDeclarations = declarations
+		revised = revised || string(declarations) != string(query.ParamDeclarations)
+	}
+	if request.Bind.HasValue() {
+		bind := request.Bind.Value != nil && *request.Bind.Value
+		attributes["bind"] = bind
+		patched.Bind = bind
+		revised = revised || bind != query.Bind
+	}
+	if request.Engine.HasValue() {
+		engine := ""
+		if request.Engine.Value != nil {
+			engine = *request.Engine.Value
+		}
+		if !template.IsEngine(engine) {
+			return fmt.Errorf("%w: unknown template engine %s", ErrInvalidPatch, engine)
+		}
+		attributes["engine"] = engine
+		patched.Engine = engine
+		revised = revised || engine != query.Engine
+	}
+
+	if revised {
+		sql, err := compile(&patched)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+		}
+		attributes["sql"] = sql
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if revised {
+			revision := models.QueryRevision{
+				QueryID:      query.ID,
+				ConnectionId: query.ConnectionId,
+				Query:        query.Query,
+				Params:       query.Params,
+
+				ParamDeclarations: query.ParamDeclarations,
+				Bind:              query.Bind,
+				Engine:            query.Engine,
+			}
+			if err := tx.Create(&revision).Error; err != nil {
+				return err
+			}
+		}
+
+		if request.Query.HasValue() {
+			if err := setDependencies(tx, query.ID, dependsOn); err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(query).Updates(attributes).Error
+	})
 }
 
 func (r *Repository) FindSectionByID(sectionId uint64) (*models.Section, error) {
@@ -119,6 +256,12 @@ func (r *Repository) Save(value interface{}) error {
 	return tx.Error
 }
 
+// UpdateQueryColumns writes only the given columns of query, so that it neither
+// overwrites concurrent edits of the others nor creates a deleted query again.
+func (r *Repository) UpdateQueryColumns(query *models.SQLQuery, columns ...string) error {
+	return r.DB.Model(&models.SQLQuery{}).Where("id = ?", query.ID).Select(columns).Updates(query).Error
+}
+
 func (r *Repository) CreateQuery(query *models.SQLQuery) error {
 	return r.DB.Create(&query).Error
 }
@@ -140,16 +283,68 @@ func (r *Repository) FindQuery(queryId uint64, where *models.SQLQuery) (*models.
 }
 
 func (r *Repository) DeleteQuery(queryId uint64) error {
-	return r.DB.Delete(&models.SQLQuery{}, queryId).Error
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("query_id = ?", queryId).Delete(&models.QueryExecution{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("query_id = ?", queryId).Delete(&models.QueryRevision{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("query_id = ? OR depends_on_id = ?", queryId, queryId).Delete(&models.QueryDependency{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.SQLQuery{}, queryId).Error
+	})
+}
+
+func (r *Repository) CreateExecution(execution *models.QueryExecution) error {
+	return r.DB.Create(execution).Error
+}
+
+// UpdateExecution writes the outcome of a run to its execution. An execution deleted
+// with its query is not created again, gorm.ErrRecordNotFound is returned instead.
+func (r *Repository) UpdateExecution(execution *models.QueryExecution) error {
+	tx := r.DB.Model(&models.QueryExecution{}).Where("id = ?", execution.ID).Select(models.ExecutionColumns).Updates(execution)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("execution %d: %w", execution.ID, gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func (r *Repository) FindExecution(executionId uint64, where *models.QueryExecution) (*models.QueryExecution, error) {
+	var execution models.QueryExecution
+	if err := r.DB.Where(where).First(&execution, executionId).Error; err != nil {
+		return nil, err
+	}
+	return &execution, nil
 }
 
 func (r *Repository) DeleteSectionByID(sectionId uint64) error {
 	return r.DB.Transaction(func(tx *gorm.DB) error {
-		if err := r.DB.Where("section_id = ?", sectionId).Delete(&models.SQLQuery{}).Error; err != nil {
+		if err := tx.Where("query_id IN (?)", tx.Model(&models.SQLQuery{}).Select("id").Where("section_id = ?", sectionId)).Delete(&models.QueryExecution{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("query_id IN (?)", tx.Model(&models.SQLQuery{}).Select("id").Where("section_id = ?", sectionId)).Delete(&models.QueryRevision{}).Error; err != nil {
+			return err
+		}
+
+		sectionQueries := tx.Model(&models.SQLQuery{}).Select("id").Where("section_id = ?", sectionId)
+		if err := tx.Where("query_id IN (?) OR depends_on_id IN (?)", sectionQueries, sectionQueries).Delete(&models.QueryDependency{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("section_id = ?", sectionId).Delete(&models.SQLQuery{}).Error; err != nil {
 			return err
 		}
 
-		if err := r.DB.Delete(&models.Section{}, sectionId).Error; err != nil {
+		if err := tx.Delete(&models.Section{}, sectionId).Error; err != nil {
 			return err
 		}
 
@@ -159,18 +354,140 @@ func (r *Repository) DeleteSectionByID(sectionId uint64) error {
 
 func (r *Repository) DeleteIssueByID(issueId uint64) error {
 	return r.DB.Transaction(func(tx *gorm.DB) error {
-		if err := r.DB.Where("issue_id = ?", issueId).Delete(&models.SQLQuery{}).Error; err != nil {
+		if err := tx.Where("query_id IN (?)", tx.Model(&models.SQLQuery{}).Select("id").Where("issue_id = ?", issueId)).Delete(&models.QueryExecution{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("query_id IN (?)", tx.Model(&models.SQLQuery{}).Select("id").Where("issue_id = ?", issueId)).Delete(&models.QueryRevision{}).Error; err != nil {
 			return err
 		}
 
-		if err := r.DB.Where("issue_id = ?", issueId).Delete(&models.Section{}).Error; err != nil {
+		issueQueries := tx.Model(&models.SQLQuery{}).Select("id").Where("issue_id = ?", issueId)
+		if err := tx.Where("query_id IN (?) OR depends_on_id IN (?)", issueQueries, issueQueries).Delete(&models.QueryDependency{}).Error; err != nil {
 			return err
 		}
 
-		if err := r.DB.Delete(&models.Issue{}, issueId).Error; err != nil {
+		if err := tx.Where("issue_id = ?", issueId).Delete(&models.SQLQuery{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("issue_id = ?", issueId).Delete(&models.Section{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&models.Issue{}, issueId).Error; err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (r *Repository) CreateSnippet(snippet *models.Snippet) error {
+	return r.DB.Create(snippet).Error
+}
+
+func (r *Repository) FindSnippet(snippetId uint64) (*models.Snippet, error) {
+	var snippet models.Snippet
+	if err := r.DB.First(&snippet, snippetId).Error; err != nil {
+		return nil, err
+	}
+	return &snippet, nil
+}
+
+func (r *Repository) FindSnippetByName(name string) (*models.Snippet, error) {
+	var snippet models.Snippet
+	if err := r.DB.Where(&models.Snippet{Name: name}).First(&snippet).Error; err != nil {
+		return nil, err
+	}
+	return &snippet, nil
+}
+
+// SaveSnippet saves the snippet, which was named previousName. A snippet cannot be
+// renamed while queries or other snippets include it, ErrSnippetInUse is returned then.
+func (r *Repository) SaveSnippet(snippet *models.Snippet, previousName string) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if snippet.Name != previousName {
+			if err := checkSnippetUnused(tx, previousName); err != nil {
+				return err
+			}
+		}
+		return tx.Save(snippet).Error
+	})
+}
+
+// DeleteSnippet deletes the snippet, unless queries or other snippets include it.
+func (r *Repository) DeleteSnippet(snippetId uint64) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		var snippet models.Snippet
+		if err := tx.First(&snippet, snippetId).Error; err != nil {
 			return err
 		}
+		if err := checkSnippetUnused(tx, snippet.Name); err != nil {
+			return err
+		}
+		return tx.Delete(&snippet).Error
+	})
+}
+
+// checkSnippetUnused returns ErrSnippetInUse naming the saved queries and snippets that include name.
+func checkSnippetUnused(tx *gorm.DB, name string) error {
+	include := "${include:" + name + "}"
+	// _ matches any character in LIKE, the matches are checked again below.
+	pattern := "%" + include + "%"
+
+	var queries []models.SQLQuery
+	if err := tx.Select("id", "query").Where("query LIKE ?", pattern).Order("id").Find(&queries).Error; err != nil {
+		return err
+	}
+	var snippets []models.Snippet
+	if err := tx.Select("name", "body").Where("body LIKE ? AND name <> ?", pattern, name).Order("name").Find(&snippets).Error; err != nil {
+		return err
+	}
+
+	users := append(lo.FilterMap(queries, func(query models.SQLQuery, index int) (string, bool) {
+		return fmt.Sprintf("query %d", query.ID), strings.Contains(query.Query, include)
+	}), lo.FilterMap(snippets, func(snippet models.Snippet, index int) (string, bool) {
+		return "snippet " + snippet.Name, strings.Contains(snippet.Body, include)
+	})...)
+	if len(users) > 0 {
+		return fmt.Errorf("%w: %s is included by %s", ErrSnippetInUse, name, strings.Join(users, ", "))
+	}
+	return nil
+}
 
+// SetDependencies replaces the queries the query depends on.
+func (r *Repository) SetDependencies(queryId uint64, dependsOn []uint64) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return setDependencies(tx, queryId, dependsOn)
+	})
+}
+
+func setDependencies(tx *gorm.DB, queryId uint64, dependsOn []uint64) error {
+	if err := tx.Where("query_id = ?", queryId).Delete(&models.QueryDependency{}).Error; err != nil {
+		return err
+	}
+
+	if len(dependsOn) == 0 {
 		return nil
+	}
+
+	dependencies := lo.Map(dependsOn, func(dependsOnId uint64, index int) models.QueryDependency {
+		return models.QueryDependency{QueryID: queryId, DependsOnID: dependsOnId}
 	})
+	return tx.Create(&dependencies).Error
+}
+
+// FindDependencies returns the dependencies of the queries, or on the queries when dependents is set.
+func (r *Repository) FindDependencies(queryIds []uint64, dependents bool) ([]models.QueryDependency, error) {
+	column := "query_id"
+	if dependents {
+		column = "depends_on_id"
+	}
+
+	var dependencies []models.QueryDependency
+	if err := r.DB.Where(column+" IN ?", queryIds).Order("id").Find(&dependencies).Error; err != nil {
+		return nil, err
+	}
+	return dependencies, nil
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"data-explorer/pkg/dataexplorer/conf"
 	"data-explorer/pkg/dataexplorer/connection"
 	"data-explorer/pkg/dataexplorer/controllers"
@@ -19,12 +20,30 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+const healthCheckTimeout = 10 * time.Second
+
 type Server struct {
-	engine *gin.Engine
+	engine           *gin.Engine
+	connectionHolder *connection.ConnectionHolder
+}
+
+type Options struct {
+	// JobWorkers is the number of asynchronous queries executed at the same time.
+	JobWorkers int
+	// JobQueueSize is how many asynchronous queries can wait for a worker.
+	JobQueueSize int
+	// MaxConcurrentQueries caps the queries running at once across all connections.
+	MaxConcurrentQueries int
+	// MaxConcurrentQueriesPerConnection applies to connections without max_concurrent_queries.
+	MaxConcurrentQueriesPerConnection int
+	// MaxQueuedQueries is how many queries can wait to run before new ones are rejected.
+	MaxQueuedQueries int
 }
 
-func NewServer(connectionsConfiguration *conf.ConnectionsConfiguration) (*Server, error) {
+func NewServer(connectionsConfiguration *conf.ConnectionsConfiguration, options Options) (*Server, error) {
 	r := gin.Default()
+	// Let queries see the request context, so that they stop when the client goes away.
+	r.ContextWithFallback = true
 
 	gormLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
@@ -48,6 +67,10 @@ func NewServer(connectionsConfiguration *conf.ConnectionsConfiguration) (*Server
 		&models.Issue{},
 		&models.Section{},
 		&models.SQLQuery{},
+		&models.QueryExecution{},
+		&models.QueryRevision{},
+		&models.Snippet{},
+		&models.QueryDependency{},
 	); err != nil {
 		return nil, err
 	}
@@ -58,19 +81,33 @@ func NewServer(connectionsConfiguration *conf.ConnectionsConfiguration) (*Server
 		})
 	})
 
+	repository := repositories.NewRepository(db)
 	connectionHolder := connection.NewConnectionHolder(connectionsConfiguration.Connections)
-	queryService, err := services.NewQueryService(connectionHolder)
+	scheduler := services.NewScheduler(options.MaxConcurrentQueries, options.MaxQueuedQueries)
+	queryService, err := services.NewQueryService(connectionHolder, scheduler, options.MaxConcurrentQueriesPerConnection, repository, repository)
 	if err != nil {
 		return nil, err
 	}
 
 	queryController := controllers.NewQueryController(queryService)
-	repository := repositories.NewRepository(db)
-	mainController := controllers.NewMainController(repository, queryService)
+	connectionController := controllers.NewConnectionController(connectionHolder)
+	snippetController := controllers.NewSnippetController(repository, queryService)
+	jobService := services.NewJobService(options.JobWorkers, options.JobQueueSize)
+	mainController := controllers.NewMainController(repository, queryService, jobService)
 
 	api := r.Group("/api")
 	{
 		api.POST("/query", queryController.Query)
+		api.POST("/query/params", queryController.DiscoverParams)
+		api.POST("/query/explain", queryController.Explain)
+		api.POST("/query/dry-run", queryController.DryRun)
+
+		api.GET("/running-queries", queryController.ListRunningQueries)
+		api.DELETE("/running-queries/:runningQueryId", queryController.CancelRunningQuery)
+
+		api.GET("/connections", connectionController.ListConnections)
+		api.GET("/connections/:connectionId", connectionController.GetConnection)
+		api.POST("/connections/:connectionId/test", connectionController.TestConnection)
 
 		api.POST("/issues", mainController.CreateIssue)
 		api.GET("/issues", mainController.ListIssues)
@@ -89,13 +126,41 @@ func NewServer(connectionsConfiguration *conf.ConnectionsConfiguration) (*Server
 		api.GET("/issues/:issueId/sections/:sectionId/queries/:queryId", mainController.GetQuery)
 		api.DELETE("/queries/:queryId", mainController.DeleteQuery)
 		api.PATCH("/queries/:queryId", mainController.PatchQuery)
+		api.POST("/queries/:queryId/run", mainController.RunQuery)
+		api.POST("/queries/:queryId/explain", mainController.ExplainQuery)
+		api.GET("/queries/:queryId/executions", mainController.ListExecutions)
+		api.GET("/queries/:queryId/executions/:executionId", mainController.GetExecution)
+		api.GET("/queries/:queryId/revisions", mainController.ListRevisions)
+		api.GET("/queries/:queryId/dependencies", mainController.ListDependencies)
+
+		api.POST("/snippets", snippetController.CreateSnippet)
+		api.GET("/snippets", snippetController.ListSnippets)
+		api.POST("/snippets/preview", snippetController.PreviewSnippets)
+		api.GET("/snippets/:snippetId", snippetController.GetSnippet)
+		api.PATCH("/snippets/:snippetId", snippetController.PatchSnippet)
+		api.DELETE("/snippets/:snippetId", snippetController.DeleteSnippet)
+
+		api.GET("/jobs/:jobId", mainController.GetJob)
+		api.GET("/jobs/:jobId/result", mainController.GetJobResult)
+		api.DELETE("/jobs/:jobId", mainController.CancelJob)
 	}
 
 	return &Server{
-		engine: r,
+		engine:           r,
+		connectionHolder: connectionHolder,
 	}, nil
 }
 
+// MonitorConnections records the health of every connection each interval, see ConnectionHolder.MonitorHealth.
+func (server *Server) MonitorConnections(ctx context.Context, interval time.Duration) {
+	server.connectionHolder.MonitorHealth(ctx, interval, healthCheckTimeout)
+}
+
+// WatchConnections reloads the connections from path on change or SIGHUP, see ConnectionHolder.Watch.
+func (server *Server) WatchConnections(ctx context.Context, path string, interval time.Duration) {
+	server.connectionHolder.Watch(ctx, path, interval)
+}
+
 func (server *Server) Run() error {
 	return server.engine.Run()
 }
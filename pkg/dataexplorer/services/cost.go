@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"data-explorer/pkg/dataexplorer/connection"
+	"fmt"
+)
+
+// CostEstimate is the estimated cost of a query against the threshold of its connection.
+type CostEstimate struct {
+	connection.Cost
+	// Threshold is the confirm_input_bytes of the connection, 0 when unset.
+	Threshold int64 `json:"confirm_input_bytes"`
+	// ConfirmationRequired tells whether the query only runs with confirm_cost.
+	ConfirmationRequired bool `json:"confirmation_required"`
+}
+
+// CostError refuses a query estimated above the confirm_input_bytes of its connection.
+type CostError struct {
+	Estimate *CostEstimate
+}
+
+func (err *CostError) Error() string {
+	return fmt.Sprintf(
+		"the query would read %d bytes, more than the %d bytes allowed without confirmation, run it again with confirm_cost",
+		err.Estimate.InputBytes,
+		err.Estimate.Threshold,
+	)
+}
+
+// EstimateCost estimates sqlQuery without running it. With script, the statements of
+// the script are estimated one by one and added up.
+func (s *QueryService) EstimateCost(
+	ctx context.Context,
+	connectionId string,
+	sqlQuery string,
+	script bool,
+) (*CostEstimate, error) {
+	conn, release, err := s.connectionHolder.AcquireConnection(connectionId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	statements := []string{sqlQuery}
+	if script {
+		statements = connection.SplitStatements(sqlQuery, conn.Driver.Dialect)
+	}
+	return estimateCost(ctx, conn, statements)
+}
+
+// checkCost returns a *CostError when the statements are estimated above the threshold of
+// the connection and options do not confirm the cost. Connections without a threshold or
+// whose driver cannot estimate are not checked.
+func checkCost(ctx context.Context, conn *connection.Connection, statements []string, options connection.QueryOptions) error {
+	if options.ConfirmCost || conn.Configuration.ConfirmInputBytes <= 0 || conn.Driver.EstimateCost == nil {
+		return nil
+	}
+
+	estimate, err := estimateCost(ctx, conn, statements)
+	if err != nil {
+		return fmt.Errorf("cannot estimate the cost of the query: %w", err)
+	}
+	if estimate.ConfirmationRequired {
+		return &CostError{Estimate: estimate}
+	}
+	return nil
+}
+
+func estimateCost(ctx context.Context, conn *connection.Connection, statements []string) (*CostEstimate, error) {
+	estimate := CostEstimate{
+		Threshold: conn.Configuration.ConfirmInputBytes,
+	}
+	for _, statement := range statements {
+		cost, err := conn.EstimateCost(ctx, statement)
+		if err != nil {
+			return nil, err
+		}
+		estimate.Add(cost)
+	}
+	estimate.ConfirmationRequired = estimate.Threshold > 0 && estimate.InputBytes > estimate.Threshold
+	return &estimate, nil
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// finished jobs are forgotten after jobRetention, their results live on in the SQLQuery rows.
+const jobRetention = time.Hour
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobQueueFull = errors.New("too many queued jobs, try again later")
+)
+
+type Job struct {
+	ID           string `json:"id"`
+	QueryID      uint64 `json:"query_id"`
+	ExecutionID  uint64 `json:"execution_id"`
+	ConnectionId string `json:"connection_id"`
+	Owner        string `json:"owner"`
+	Status       string `json:"status"`
+	RowsRead     int64  `json:"rows_read"`
+	// QueuePosition is the place of the query in the scheduler queue once the job runs, 0 when executing.
+	QueuePosition int64      `json:"queue_position"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+
+	rowsRead      atomic.Int64
+	queuePosition atomic.Int64
+	run           func(ctx context.Context, job *Job) error
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+// SetRowsRead records the progress of the job, it matches connection.QueryOptions.Progress.
+func (job *Job) SetRowsRead(rowCount int) {
+	job.rowsRead.Store(int64(rowCount))
+}
+
+// SetQueuePosition records the place of the job's query in the scheduler queue, it matches connection.QueryOptions.Queued.
+func (job *Job) SetQueuePosition(position int) {
+	job.queuePosition.Store(int64(position))
+}
+
+// JobService runs queries in the background on a fixed number of workers.
+type JobService struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+}
+
+func NewJobService(workers int, queueSize int) *JobService {
+	s := &JobService{
+		jobs:  map[string]*Job{},
+		queue: make(chan *Job, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Submit queues run for execution. run receives a context that is cancelled when the job
+// is. A job cancelled before a worker picks it up still gets run, with its context
+// already cancelled, so that run can record the cancellation.
+func (s *JobService) Submit(ctx context.Context, queryID uint64, executionID uint64, connectionId string, run func(ctx context.Context, job *Job) error) (*Job, error) {
+	owner := ownerFrom(ctx)
+	jobCtx, cancel := context.WithCancel(WithOwner(context.Background(), owner))
+
+	job := &Job{
+		ID:           uuid.NewString(),
+		QueryID:      queryID,
+		ExecutionID:  executionID,
+		ConnectionId: connectionId,
+		Owner:        owner,
+		Status:       JobPending,
+		CreatedAt:    time.Now(),
+		run:          run,
+		ctx:          jobCtx,
+		cancel:       cancel,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+
+	select {
+	case s.queue <- job:
+	default:
+		cancel()
+		return nil, ErrJobQueueFull
+	}
+	s.jobs[job.ID] = job
+
+	return s.snapshot(job), nil
+}
+
+// Get returns a copy of the job as it is now.
+func (s *JobService) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return s.snapshot(job), nil
+}
+
+// Cancel cancels the context of the job, which kills its running statement on the
+// server, see connection.QueryOptions.Kill.
+func (s *JobService) Cancel(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if job.Status == JobPending {
+		s.finish(job, context.Canceled)
+		job.cancel()
+		// Workers skip the job from now on.
+		go func() {
+			_ = job.run(job.ctx, job)
+		}()
+		return s.snapshot(job), nil
+	}
+	job.cancel()
+	return s.snapshot(job), nil
+}
+
+func (s *JobService) work() {
+	for job := range s.queue {
+		s.mu.Lock()
+		if job.Status != JobPending {
+			s.mu.Unlock()
+			continue
+		}
+		startedAt := time.Now()
+		job.Status = JobRunning
+		job.StartedAt = &startedAt
+		s.mu.Unlock()
+
+		err := job.run(job.ctx, job)
+
+		s.mu.Lock()
+		s.finish(job, err)
+		s.mu.Unlock()
+		job.cancel()
+	}
+}
+
+func (s *JobService) finish(job *Job, err error) {
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+
+	switch {
+	case err == nil:
+		job.Status = JobSucceeded
+	case errors.Is(err, context.Canceled) || errors.Is(job.ctx.Err(), context.Canceled):
+		job.Status = JobCancelled
+	default:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	}
+}
+
+func (s *JobService) snapshot(job *Job) *Job {
+	return &Job{
+		ID:            job.ID,
+		QueryID:       job.QueryID,
+		ExecutionID:   job.ExecutionID,
+		ConnectionId:  job.ConnectionId,
+		Owner:         job.Owner,
+		Status:        job.Status,
+		RowsRead:      job.rowsRead.Load(),
+		QueuePosition: job.queuePosition.Load(),
+		Error:         job.Error,
+		CreatedAt:     job.CreatedAt,
+		StartedAt:     job.StartedAt,
+		FinishedAt:    job.FinishedAt,
+	}
+}
+
+func (s *JobService) prune() {
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
@@ -3,16 +3,51 @@ package services
 import (
 	"context"
 	"data-explorer/pkg/dataexplorer/connection"
+	"data-explorer/pkg/dataexplorer/models"
 	"data-explorer/pkg/dataexplorer/template"
+	"errors"
+	"fmt"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/samber/lo"
 )
 
+// SnippetStore finds the snippets included by queries.
+type SnippetStore interface {
+	FindSnippetByName(name string) (*models.Snippet, error)
+}
+
+// QueryStore finds the saved queries whose results are referenced by other queries.
+type QueryStore interface {
+	FindQuery(queryId uint64, where *models.SQLQuery) (*models.SQLQuery, error)
+}
+
+var resultJSON = jsoniter.Config{UseNumber: true}.Froze()
+
 type QueryService struct {
 	connectionHolder *connection.ConnectionHolder
+	scheduler        *Scheduler
+	snippets         SnippetStore
+	queries          QueryStore
+	// maxConcurrentPerConnection applies to connections without max_concurrent_queries.
+	maxConcurrentPerConnection int
+	RunningQueries             *RunningQueries
 }
 
-func NewQueryService(connectionHolder *connection.ConnectionHolder) (*QueryService, error) {
+func NewQueryService(
+	connectionHolder *connection.ConnectionHolder,
+	scheduler *Scheduler,
+	maxConcurrentPerConnection int,
+	snippets SnippetStore,
+	queries QueryStore,
+) (*QueryService, error) {
 	return &QueryService{
-		connectionHolder: connectionHolder,
+		connectionHolder:           connectionHolder,
+		scheduler:                  scheduler,
+		snippets:                   snippets,
+		queries:                    queries,
+		maxConcurrentPerConnection: maxConcurrentPerConnection,
+		RunningQueries:             NewRunningQueries(),
 	}, nil
 }
 
@@ -20,21 +55,315 @@ func (s *QueryService) Query(
 	ctx context.Context,
 	connectionId string,
 	sqlQuery string,
+	options connection.QueryOptions,
 ) (*connection.QueryResult, error) {
-	db, err := s.connectionHolder.GetDB(connectionId)
+	conn, release, err := s.connectionHolder.AcquireConnection(connectionId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := conn.CheckStatements(connection.SplitStatements(sqlQuery, conn.Driver.Dialect)); err != nil {
+		return nil, err
+	}
+
+	ctx, finish, err := s.begin(ctx, conn, sqlQuery, &options)
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+
+	if err := checkCost(ctx, conn, []string{sqlQuery}, options); err != nil {
+		return nil, err
+	}
+
+	return conn.Query(ctx, sqlQuery, options)
+}
+
+// Stream runs the query and passes rows to handler as they arrive instead of buffering them.
+func (s *QueryService) Stream(
+	ctx context.Context,
+	connectionId string,
+	sqlQuery string,
+	options connection.QueryOptions,
+	handler connection.RowHandler,
+) (*connection.ResultSummary, error) {
+	conn, release, err := s.connectionHolder.AcquireConnection(connectionId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := conn.CheckStatements(connection.SplitStatements(sqlQuery, conn.Driver.Dialect)); err != nil {
+		return nil, err
+	}
+
+	ctx, finish, err := s.begin(ctx, conn, sqlQuery, &options)
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+
+	if err := checkCost(ctx, conn, []string{sqlQuery}, options); err != nil {
+		return nil, err
+	}
+
+	return conn.Stream(ctx, sqlQuery, options, handler)
+}
+
+// Script splits script into statements with the dialect of the connection and runs
+// them one after the other on a single session, see connection.Connection.Script.
+func (s *QueryService) Script(
+	ctx context.Context,
+	connectionId string,
+	script string,
+	options connection.QueryOptions,
+) ([]*connection.StatementResult, error) {
+	conn, release, err := s.connectionHolder.AcquireConnection(connectionId)
 	if err != nil {
 		return nil, err
 	}
-	return connection.Query(ctx, db, sqlQuery)
+	defer release()
+
+	statements := connection.SplitStatements(script, conn.Driver.Dialect)
+	if err := conn.CheckStatements(statements); err != nil {
+		return nil, err
+	}
+
+	ctx, finish, err := s.begin(ctx, conn, script, &options)
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+
+	if err := checkCost(ctx, conn, statements, options); err != nil {
+		return nil, err
+	}
+
+	return conn.Script(ctx, statements, options)
 }
 
-func (s *QueryService) CompileSQL(
+// Explain returns the plan of the compiled sqlQuery without running it. sqlQuery must
+// be a single statement, so that nothing runs after the explained one. It is scheduled
+// and listed with the running queries, since EXPLAIN ANALYZE does run it.
+func (s *QueryService) Explain(
+	ctx context.Context,
+	connectionId string,
+	sqlQuery string,
+	args []interface{},
+) (*connection.Plan, error) {
+	conn, release, err := s.connectionHolder.AcquireConnection(connectionId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if len(connection.SplitStatements(sqlQuery, conn.Driver.Dialect)) > 1 {
+		return nil, errors.New("only a single statement can be explained")
+	}
+
+	options := connection.QueryOptions{Args: args}
+	ctx, finish, err := s.begin(ctx, conn, sqlQuery, &options)
+	if err != nil {
+		return nil, err
+	}
+	defer finish()
+
+	return conn.Explain(ctx, sqlQuery, options)
+}
+
+// begin registers the query as running and waits for the scheduler to let it run.
+// The returned function must be called once the query is done.
+func (s *QueryService) begin(
+	ctx context.Context,
+	conn *connection.Connection,
+	sqlQuery string,
+	options *connection.QueryOptions,
+) (context.Context, func(), error) {
+	ctx, running := s.RunningQueries.Start(ctx, conn.Id, sqlQuery)
+	options.Kill = true
+
+	limit := conn.Configuration.MaxConcurrentQueries
+	if limit == 0 {
+		limit = s.maxConcurrentPerConnection
+	}
+
+	queued := options.Queued
+	release, err := s.scheduler.Acquire(ctx, conn.Id, limit, func(position int) {
+		running.queued(position)
+		if queued != nil {
+			queued(position)
+		}
+	})
+	if err != nil {
+		s.RunningQueries.Finish(running)
+		return nil, nil, err
+	}
+
+	return ctx, func() {
+		release()
+		s.RunningQueries.Finish(running)
+	}, nil
+}
+
+// ExpandSQL replaces the ${include:name} directives of sqlQuery with their snippets,
+// then the ${query.<id>.column.<name>} references with the values of the stored result,
+// written as literals of dialect. Only the saved queries of the issue can be referenced.
+func (s *QueryService) ExpandSQL(sqlQuery string, issueId uint64, dialect connection.Dialect) (string, error) {
+	sqlQuery, err := s.ExpandIncludes(sqlQuery)
+	if err != nil {
+		return "", err
+	}
+
+	return template.ExpandQueryReferences(sqlQuery, func(reference template.QueryReference) ([]interface{}, error) {
+		return s.referencedValues(issueId, reference)
+	}, dialect)
+}
+
+// QueryReferences returns the ids of the saved queries whose results sqlQuery references.
+func (s *QueryService) QueryReferences(sqlQuery string) ([]uint64, error) {
+	sqlQuery, err := s.ExpandIncludes(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Uniq(lo.Map(template.QueryReferences(sqlQuery), func(reference template.QueryReference, index int) uint64 {
+		return reference.QueryID
+	})), nil
+}
+
+// ExpandIncludes replaces the ${include:name} directives of sqlQuery with their snippets.
+func (s *QueryService) ExpandIncludes(sqlQuery string) (string, error) {
+	return template.ExpandIncludes(sqlQuery, s.SnippetBody)
+}
+
+// SnippetBody returns the body of the snippet included with ${include:name}.
+func (s *QueryService) SnippetBody(name string) (string, error) {
+	if s.snippets == nil {
+		return "", errors.New("snippets are not available")
+	}
+	snippet, err := s.snippets.FindSnippetByName(name)
+	if err != nil {
+		return "", err
+	}
+	return snippet.Body, nil
+}
+
+// referencedValues returns the values of the column of the stored result of a saved
+// query of the issue.
+func (s *QueryService) referencedValues(issueId uint64, reference template.QueryReference) ([]interface{}, error) {
+	if s.queries == nil {
+		return nil, errors.New("saved queries are not available")
+	}
+	if issueId == 0 {
+		return nil, fmt.Errorf("query %d cannot be referenced outside of an issue", reference.QueryID)
+	}
+
+	query, err := s.queries.FindQuery(reference.QueryID, &models.SQLQuery{IssueID: issueId})
+	if err != nil {
+		return nil, fmt.Errorf("query %d of issue %d: %w", reference.QueryID, issueId, err)
+	}
+	if len(query.Result) == 0 {
+		return nil, fmt.Errorf("query %d has no result, run it first", reference.QueryID)
+	}
+
+	var result struct {
+		ColumnNames []string        `json:"column_names"`
+		Records     [][]interface{} `json:"records"`
+	}
+	// Numbers are kept as they were written, float64 would mangle large ids.
+	if err := resultJSON.Unmarshal(query.Result, &result); err != nil {
+		return nil, fmt.Errorf("query %d: %w", reference.QueryID, err)
+	}
+
+	index := lo.IndexOf(result.ColumnNames, reference.Column)
+	if index < 0 {
+		return nil, fmt.Errorf("query %d has no column %s", reference.QueryID, reference.Column)
+	}
+
+	return lo.Map(result.Records, func(record []interface{}, _ int) interface{} {
+		return record[index]
+	}), nil
+}
+
+// CompileOptions tells CompileQuery how to turn a query into SQL.
+type CompileOptions struct {
+	Declarations []template.ParamDeclaration
+	// Engine is template.EngineSimple, the default, or template.EngineGo.
+	Engine string
+	// Bind passes values as query arguments, for the simple engine only.
+	Bind bool
+	// IssueID is the issue the query belongs to, whose saved queries it can reference.
+	IssueID uint64
+}
+
+// CompileQuery expands includes and references, validates params and compiles
+// sqlQuery for the connection with the engine of options. Placeholders left without
+// a value are reported as missing in a *template.ParamsError. With Bind, values are
+// passed as arguments through the bind placeholders of the driver of the connection,
+// so that they never end up in the SQL text. Only identifier params are substituted then.
+func (s *QueryService) CompileQuery(
+	connectionId string,
 	sqlQuery string,
 	params map[string]string,
-) string {
-	if params != nil {
-		sqlQuery = template.SimpleCompile(sqlQuery, params)
+	options CompileOptions,
+) (string, []interface{}, error) {
+	if !template.IsEngine(options.Engine) {
+		return "", nil, fmt.Errorf("unknown template engine %s", options.Engine)
+	}
+	if options.Engine == template.EngineGo && options.Bind {
+		return "", nil, fmt.Errorf("bind params are not supported by the %s engine", options.Engine)
+	}
+
+	driver, err := s.connectionHolder.GetDriver(connectionId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sqlQuery, err = s.ExpandSQL(sqlQuery, options.IssueID, driver.Dialect)
+	if err != nil {
+		return "", nil, err
+	}
+
+	values, err := resolveParams(sqlQuery, options.Declarations, params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case options.Engine == template.EngineGo:
+		sql, err := template.GoCompile(sqlQuery, values, options.Declarations, driver.Dialect)
+		return sql, nil, err
+	case !options.Bind:
+		return template.SimpleCompile(sqlQuery, values), nil, nil
+	case driver.Placeholder == nil:
+		return "", nil, fmt.Errorf("connection %s does not support bind params", connectionId)
+	}
+	return template.BindCompile(sqlQuery, values, options.Declarations, driver.Placeholder, driver.Dialect)
+}
+
+// resolveParams validates params and returns the values to compile sqlQuery with.
+func resolveParams(
+	sqlQuery string,
+	declarations []template.ParamDeclaration,
+	params map[string]string,
+) (map[string]string, error) {
+	paramsError := &template.ParamsError{}
+	values, err := template.ValidateParams(declarations, params)
+	if err != nil && !errors.As(err, &paramsError) {
+		return nil, err
+	}
+
+	for _, name := range template.Placeholders(sqlQuery) {
+		_, declared := lo.Find(declarations, func(declaration template.ParamDeclaration) bool {
+			return declaration.Name == name
+		})
+		if _, ok := params[name]; !ok && !declared {
+			paramsError.Errors = append(paramsError.Errors, template.ParamError{Name: name, Reason: "is required"})
+		}
+	}
+	if len(paramsError.Errors) > 0 {
+		return nil, paramsError
 	}
 
-	return sqlQuery
+	return values, nil
 }
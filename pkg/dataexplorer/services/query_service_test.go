@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"data-explorer/pkg/dataexplorer/conf"
+	"data-explorer/pkg/dataexplorer/connection"
+	"data-explorer/pkg/dataexplorer/template"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newSQLiteQueryService serves a sqlite database with a users table as "local", and
+// the same database as "reader", a read-only connection returning at most 2 rows.
+func newSQLiteQueryService(t *testing.T) *QueryService {
+	t.Helper()
+
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "test.db")
+	holder := connection.NewConnectionHolder([]conf.Connection{
+		{Id: "local", DSN: dsn},
+		{Id: "reader", DSN: dsn, ReadOnly: true, MaxRows: 2},
+	})
+	service, err := NewQueryService(holder, NewScheduler(2, 0), 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = service.Script(context.Background(), "local", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, score REAL);
+		INSERT INTO users (id, name, score) VALUES (1, 'ada', 1.5), (2, 'bob', NULL), (3, 'o''brien', 3);
+	`, connection.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestQueryServiceQuery(t *testing.T) {
+	service := newSQLiteQueryService(t)
+
+	result, err := service.Query(context.Background(), "local", "SELECT id, name, score FROM users ORDER BY id", connection.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(result.ColumnNames); got != "[id name score]" {
+		t.Errorf("columns = %s, want [id name score]", got)
+	}
+	if got := fmt.Sprint(result.Records); got != "[[1 ada 1.5] [2 bob <nil>] [3 o'brien 3]]" {
+		t.Errorf("records = %s", got)
+	}
+	if result.RowCount != 3 || result.Truncated {
+		t.Errorf("row_count = %d, truncated = %v, want 3 rows", result.RowCount, result.Truncated)
+	}
+
+	result, err = service.Query(context.Background(), "local", "SELECT id FROM users ORDER BY id", connection.QueryOptions{MaxRows: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowCount != 2 || !result.Truncated || result.Limit != 2 {
+		t.Errorf("row_count = %d, truncated = %v, limit = %d, want 2 truncated rows", result.RowCount, result.Truncated, result.Limit)
+	}
+
+	if queries := service.RunningQueries.List(); len(queries) != 0 {
+		t.Errorf("running queries = %v, want none once done", queries)
+	}
+}
+
+func TestQueryServiceBind(t *testing.T) {
+	service := newSQLiteQueryService(t)
+
+	sql, args, err := service.CompileQuery("local", "SELECT name FROM users WHERE name = ${name} OR id IN (${ids})", map[string]string{
+		"name": "o'brien",
+		"ids":  "1, 2",
+	}, CompileOptions{
+		Declarations: []template.ParamDeclaration{{Name: "ids", Type: template.ParamList}},
+		Bind:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != "SELECT name FROM users WHERE name = ? OR id IN (?, ?)" {
+		t.Errorf("sql = %q", sql)
+	}
+
+	result, err := service.Query(context.Background(), "local", sql+" ORDER BY id", connection.QueryOptions{Args: args})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(result.Records); got != "[[ada] [bob] [o'brien]]" {
+		t.Errorf("records = %s", got)
+	}
+}
+
+func TestQueryServiceReadOnly(t *testing.T) {
+	service := newSQLiteQueryService(t)
+
+	result, err := service.Query(context.Background(), "reader", "SELECT id FROM users", connection.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowCount != 2 || !result.Truncated {
+		t.Errorf("row_count = %d, truncated = %v, want the 2 rows of max_rows", result.RowCount, result.Truncated)
+	}
+
+	var notAllowed *connection.StatementNotAllowedError
+	_, err = service.Query(context.Background(), "reader", "DELETE FROM users", connection.QueryOptions{})
+	if !errors.As(err, &notAllowed) {
+		t.Errorf("DELETE on a read-only connection = %v, want a *StatementNotAllowedError", err)
+	}
+	_, err = service.Script(context.Background(), "reader", "SELECT 1; DROP TABLE users", connection.QueryOptions{})
+	if !errors.As(err, &notAllowed) {
+		t.Errorf("DROP in a script on a read-only connection = %v, want a *StatementNotAllowedError", err)
+	}
+}
+
+func TestQueryServiceStream(t *testing.T) {
+	service := newSQLiteQueryService(t)
+
+	result := &connection.QueryResult{}
+	summary, err := service.Stream(context.Background(), "local", "SELECT name FROM users ORDER BY id", connection.QueryOptions{MaxRows: 1}, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(result.Records); got != "[[ada]]" {
+		t.Errorf("records = %s, want [[ada]]", got)
+	}
+	if summary.RowCount != 1 || !summary.Truncated {
+		t.Errorf("row_count = %d, truncated = %v, want 1 truncated row", summary.RowCount, summary.Truncated)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := service.Stream(ctx, "local", "SELECT name FROM users", connection.QueryOptions{}, result); !errors.Is(err, context.Canceled) {
+		t.Errorf("Stream with a cancelled context = %v, want context.Canceled", err)
+	}
+}
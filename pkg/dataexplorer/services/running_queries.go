@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrRunningQueryNotFound = errors.New("running query not found")
+
+type ownerKey struct{}
+
+// WithOwner attaches the user running queries to the context.
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+func ownerFrom(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
+
+const (
+	RunningQueryQueued  = "queued"
+	RunningQueryRunning = "running"
+)
+
+type RunningQuery struct {
+	ID           string    `json:"id"`
+	Owner        string    `json:"owner"`
+	ConnectionId string    `json:"connection_id"`
+	SQL          string    `json:"sql"`
+	StartedAt    time.Time `json:"started_at"`
+
+	mu            sync.Mutex
+	queuePosition int
+	cancel        context.CancelFunc
+}
+
+// RunningQueryInfo is what the API reports about a running query.
+type RunningQueryInfo struct {
+	ID            string    `json:"id"`
+	Owner         string    `json:"owner"`
+	ConnectionId  string    `json:"connection_id"`
+	SQL           string    `json:"sql"`
+	StartedAt     time.Time `json:"started_at"`
+	Status        string    `json:"status"`
+	QueuePosition int       `json:"queue_position"`
+}
+
+func (query *RunningQuery) queued(position int) {
+	query.mu.Lock()
+	defer query.mu.Unlock()
+
+	query.queuePosition = position
+}
+
+func (query *RunningQuery) info() RunningQueryInfo {
+	query.mu.Lock()
+	defer query.mu.Unlock()
+
+	status := RunningQueryRunning
+	if query.queuePosition > 0 {
+		status = RunningQueryQueued
+	}
+	return RunningQueryInfo{
+		ID:            query.ID,
+		Owner:         query.Owner,
+		ConnectionId:  query.ConnectionId,
+		SQL:           query.SQL,
+		StartedAt:     query.StartedAt,
+		Status:        status,
+		QueuePosition: query.queuePosition,
+	}
+}
+
+// Cancel cancels the context of the query, which kills its statement on the server
+// where supported, see connection.QueryOptions.Kill.
+func (query *RunningQuery) Cancel() {
+	query.cancel()
+}
+
+// RunningQueries keeps track of the queries currently executed by QueryService.
+type RunningQueries struct {
+	mu      sync.Mutex
+	queries map[string]*RunningQuery
+}
+
+func NewRunningQueries() *RunningQueries {
+	return &RunningQueries{
+		queries: map[string]*RunningQuery{},
+	}
+}
+
+// Start registers a query and returns the context it must run with.
+func (r *RunningQueries) Start(ctx context.Context, connectionId string, sql string) (context.Context, *RunningQuery) {
+	ctx, cancel := context.WithCancel(ctx)
+	query := &RunningQuery{
+		ID:           uuid.NewString(),
+		Owner:        ownerFrom(ctx),
+		ConnectionId: connectionId,
+		SQL:          sql,
+		StartedAt:    time.Now(),
+		cancel:       cancel,
+	}
+
+	r.mu.Lock()
+	r.queries[query.ID] = query
+	r.mu.Unlock()
+
+	return ctx, query
+}
+
+func (r *RunningQueries) Finish(query *RunningQuery) {
+	r.mu.Lock()
+	delete(r.queries, query.ID)
+	r.mu.Unlock()
+
+	query.cancel()
+}
+
+// List returns the running and queued queries, oldest first.
+func (r *RunningQueries) List() []RunningQueryInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queries := make([]RunningQueryInfo, 0, len(r.queries))
+	for _, query := range r.queries {
+		queries = append(queries, query.info())
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].StartedAt.Before(queries[j].StartedAt)
+	})
+	return queries
+}
+
+func (r *RunningQueries) Cancel(id string) error {
+	r.mu.Lock()
+	query, ok := r.queries[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return ErrRunningQueryNotFound
+	}
+	query.Cancel()
+	return nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("too many queries are waiting to run, try again later")
+
+// Scheduler bounds how many queries run at once, globally and per connection.
+// Queries over the limits wait in a FIFO queue; a waiting query only starts before
+// an older one when the older one is held back by its own connection limit.
+type Scheduler struct {
+	mu                   sync.Mutex
+	maxConcurrent        int
+	maxQueued            int
+	running              int
+	runningPerConnection map[string]int
+	queue                []*ticket
+}
+
+type ticket struct {
+	connectionId string
+	limit        int
+	ready        chan struct{}
+	position     func(position int)
+}
+
+// NewScheduler creates a scheduler, 0 disables the corresponding limit.
+func NewScheduler(maxConcurrent int, maxQueued int) *Scheduler {
+	return &Scheduler{
+		maxConcurrent:        maxConcurrent,
+		maxQueued:            maxQueued,
+		runningPerConnection: map[string]int{},
+	}
+}
+
+// Acquire waits until the query may run and returns the function that frees its slot.
+// limit is the concurrency limit of the connection, 0 for unlimited. position is
+// called with the 1-based place in the queue while waiting and 0 once running, it may be nil.
+func (s *Scheduler) Acquire(ctx context.Context, connectionId string, limit int, position func(position int)) (func(), error) {
+	t := &ticket{
+		connectionId: connectionId,
+		limit:        limit,
+		ready:        make(chan struct{}),
+		position:     position,
+	}
+
+	s.mu.Lock()
+	if len(s.queue) == 0 && s.canRun(t) {
+		s.start(t)
+		s.mu.Unlock()
+		s.notify(t, 0)
+		return s.releaser(t), nil
+	}
+	if s.maxQueued > 0 && len(s.queue) >= s.maxQueued {
+		s.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	s.queue = append(s.queue, t)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return s.releaser(t), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		select {
+		case <-t.ready:
+			// Started while being cancelled, give the slot back.
+			s.release(t)
+		default:
+			s.remove(t)
+		}
+		s.dispatch()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Scheduler) releaser(t *ticket) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.release(t)
+			s.dispatch()
+		})
+	}
+}
+
+func (s *Scheduler) canRun(t *ticket) bool {
+	if s.maxConcurrent > 0 && s.running >= s.maxConcurrent {
+		return false
+	}
+	return t.limit <= 0 || s.runningPerConnection[t.connectionId] < t.limit
+}
+
+func (s *Scheduler) start(t *ticket) {
+	s.running++
+	s.runningPerConnection[t.connectionId]++
+}
+
+func (s *Scheduler) release(t *ticket) {
+	s.running--
+	s.runningPerConnection[t.connectionId]--
+	if s.runningPerConnection[t.connectionId] <= 0 {
+		delete(s.runningPerConnection, t.connectionId)
+	}
+}
+
+func (s *Scheduler) remove(t *ticket) {
+	for idx := range s.queue {
+		if s.queue[idx] == t {
+			s.queue = append(s.queue[:idx], s.queue[idx+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch starts every queued ticket that fits, oldest first, and refreshes the
+// positions of the others. It must be called with mu held.
+func (s *Scheduler) dispatch() {
+	waiting := s.queue[:0]
+	for _, t := range s.queue {
+		if s.canRun(t) {
+			s.start(t)
+			s.notify(t, 0)
+			close(t.ready)
+			continue
+		}
+		waiting = append(waiting, t)
+	}
+	s.queue = waiting
+
+	for idx, t := range s.queue {
+		s.notify(t, idx+1)
+	}
+}
+
+func (s *Scheduler) notify(t *ticket, position int) {
+	if t.position != nil {
+		t.position(position)
+	}
+}
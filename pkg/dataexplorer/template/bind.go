@@ -0,0 +1,96 @@
+package template
+
+import (
+	"data-explorer/pkg/dataexplorer/connection"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+var identifierValue = regexp.MustCompile(`^[A-Za-z_][\w$]*(\.[A-Za-z_][\w$]*)*$`)
+
+// BindCompile compiles template for drivers that take arguments: every ${name} with a
+// value becomes the placeholder returned by placeholder for its 1-based position and
+// the value is returned as an argument, typed after its declaration. Identifier
+// params are substituted as they are and a list param becomes one placeholder per item.
+// Placeholders are read with the lexical rules of dialect: those in comments are left
+// as they are and a quoted one is an error, as a bound value cannot be part of a literal.
+func BindCompile(
+	template string,
+	vars map[string]string,
+	declarations []ParamDeclaration,
+	placeholder func(index int) string,
+	dialect connection.Dialect,
+) (string, []interface{}, error) {
+	var args []interface{}
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return placeholder(len(args))
+	}
+
+	segments := connection.Segments(template, dialect)
+	var sb strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(template, -1) {
+		name := template[match[2]:match[3]]
+		value, ok := vars[name]
+		segment := segments[sort.Search(len(segments), func(i int) bool {
+			return segments[i].End > match[0]
+		})]
+		if !ok || segment.Kind == connection.CommentSegment {
+			continue
+		}
+
+		declaration, _ := lo.Find(declarations, func(declaration ParamDeclaration) bool {
+			return declaration.Name == name
+		})
+		sb.WriteString(template[last:match[0]])
+		last = match[1]
+
+		switch {
+		case declaration.Identifier:
+			sb.WriteString(value)
+		case segment.Kind == connection.QuotedSegment:
+			return "", nil, fmt.Errorf("param %s is quoted, remove the quotes around ${%s} to bind it", name, name)
+		case declaration.Type == ParamList:
+			items := SplitList(value)
+			if len(items) == 0 {
+				sb.WriteString(bind(nil))
+				continue
+			}
+			sb.WriteString(strings.Join(lo.Map(items, func(item string, index int) string {
+				return bind(item)
+			}), ", "))
+		default:
+			arg, err := declaration.arg(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("param %s %w", name, err)
+			}
+			sb.WriteString(bind(arg))
+		}
+	}
+	sb.WriteString(template[last:])
+
+	return sb.String(), args, nil
+}
+
+// arg converts a validated value into the argument passed to the driver, nil for an empty value.
+func (declaration *ParamDeclaration) arg(value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	switch declaration.Type {
+	case ParamInt:
+		return strconv.ParseInt(value, 10, 64)
+	case ParamFloat:
+		return strconv.ParseFloat(value, 64)
+	case ParamBool:
+		return strconv.ParseBool(value)
+	}
+	return value, nil
+}
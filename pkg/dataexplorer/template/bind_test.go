@@ -0,0 +1,186 @@
+package template
+
+import (
+	"data-explorer/pkg/dataexplorer/connection"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var (
+	mysqlDialect    = connection.Dialect{BackslashEscapes: true, HashComments: true, ExecutableComments: true}
+	postgresDialect = connection.Dialect{DollarQuotes: true, EscapeStrings: true}
+)
+
+func dollarPlaceholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+func TestBindCompile(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     string
+		vars         map[string]string
+		declarations []ParamDeclaration
+		want         string
+		wantArgs     []interface{}
+		wantErr      bool
+	}{
+		{
+			name:     "string",
+			template: "SELECT * FROM t WHERE name = ${name}",
+			vars:     map[string]string{"name": "x'; DROP TABLE t; --"},
+			want:     "SELECT * FROM t WHERE name = $1",
+			wantArgs: []interface{}{"x'; DROP TABLE t; --"},
+		},
+		{
+			name:     "repeated",
+			template: "SELECT ${a}, ${b}, ${a}",
+			vars:     map[string]string{"a": "1", "b": "2"},
+			want:     "SELECT $1, $2, $3",
+			wantArgs: []interface{}{"1", "2", "1"},
+		},
+		{
+			name:         "typed",
+			template:     "SELECT ${n}, ${f}, ${b}, ${d}",
+			vars:         map[string]string{"n": "42", "f": "1.5", "b": "true", "d": "2024-01-31"},
+			declarations: []ParamDeclaration{{Name: "n", Type: ParamInt}, {Name: "f", Type: ParamFloat}, {Name: "b", Type: ParamBool}, {Name: "d", Type: ParamDate}},
+			want:         "SELECT $1, $2, $3, $4",
+			wantArgs:     []interface{}{int64(42), 1.5, true, "2024-01-31"},
+		},
+		{
+			name:         "empty",
+			template:     "SELECT ${n}",
+			vars:         map[string]string{"n": ""},
+			declarations: []ParamDeclaration{{Name: "n", Type: ParamInt}},
+			want:         "SELECT $1",
+			wantArgs:     []interface{}{nil},
+		},
+		{
+			name:         "invalid typed value",
+			template:     "SELECT ${n}",
+			vars:         map[string]string{"n": "x"},
+			declarations: []ParamDeclaration{{Name: "n", Type: ParamInt}},
+			wantErr:      true,
+		},
+		{
+			name:         "cast",
+			template:     "SELECT ${n}::int, x::text = ${s}::text",
+			vars:         map[string]string{"n": "1", "s": "a"},
+			declarations: []ParamDeclaration{{Name: "n", Type: ParamInt}},
+			want:         "SELECT $1::int, x::text = $2::text",
+			wantArgs:     []interface{}{int64(1), "a"},
+		},
+		{
+			name:         "list",
+			template:     "SELECT * FROM t WHERE id IN (${ids}) AND a = ${a}",
+			vars:         map[string]string{"ids": "1,2,3", "a": "x"},
+			declarations: []ParamDeclaration{{Name: "ids", Type: ParamList}},
+			want:         "SELECT * FROM t WHERE id IN ($1, $2, $3) AND a = $4",
+			wantArgs:     []interface{}{"1", "2", "3", "x"},
+		},
+		{
+			name:         "empty list",
+			template:     "SELECT * FROM t WHERE id IN (${ids})",
+			vars:         map[string]string{"ids": ""},
+			declarations: []ParamDeclaration{{Name: "ids", Type: ParamList}},
+			want:         "SELECT * FROM t WHERE id IN ($1)",
+			wantArgs:     []interface{}{nil},
+		},
+		{
+			name:         "identifier",
+			template:     "SELECT * FROM ${table} WHERE id = ${id}",
+			vars:         map[string]string{"table": "public.users", "id": "1"},
+			declarations: []ParamDeclaration{{Name: "table", Identifier: true}},
+			want:         "SELECT * FROM public.users WHERE id = $1",
+			wantArgs:     []interface{}{"1"},
+		},
+		{
+			name:         "quoted identifier",
+			template:     `SELECT * FROM "${table}"`,
+			vars:         map[string]string{"table": "users"},
+			declarations: []ParamDeclaration{{Name: "table", Identifier: true}},
+			want:         `SELECT * FROM "users"`,
+		},
+		{
+			name:     "quoted",
+			template: "SELECT * FROM t WHERE name = '${name}'",
+			vars:     map[string]string{"name": "x"},
+			wantErr:  true,
+		},
+		{
+			name:     "in a string",
+			template: "SELECT * FROM t WHERE name LIKE '%${name}%'",
+			vars:     map[string]string{"name": "x"},
+			wantErr:  true,
+		},
+		{
+			name:     "in dollar quotes",
+			template: "SELECT $$ ${name} $$",
+			vars:     map[string]string{"name": "x"},
+			wantErr:  true,
+		},
+		{
+			name:     "in an escape string",
+			template: `SELECT E'\' ${name}'`,
+			vars:     map[string]string{"name": "x"},
+			wantErr:  true,
+		},
+		{
+			name:     "in comments",
+			template: "SELECT ${a} -- ${a}\n/* ${a} */ + ${b}",
+			vars:     map[string]string{"a": "1", "b": "2"},
+			want:     "SELECT $1 -- ${a}\n/* ${a} */ + $2",
+			wantArgs: []interface{}{"1", "2"},
+		},
+		{
+			name:     "without a value",
+			template: "SELECT ${a}, ${query.1.column.id}",
+			vars:     map[string]string{"a": "1"},
+			want:     "SELECT $1, ${query.1.column.id}",
+			wantArgs: []interface{}{"1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, args, err := BindCompile(test.template, test.vars, test.declarations, dollarPlaceholder, postgresDialect)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("BindCompile(%q) = %q, want an error", test.template, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BindCompile(%q) failed: %v", test.template, err)
+			}
+			if got != test.want {
+				t.Errorf("BindCompile(%q) = %q, want %q", test.template, got, test.want)
+			}
+			if !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("BindCompile(%q) args = %#v, want %#v", test.template, args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestBindCompileMySQL(t *testing.T) {
+	// A backslash escapes the quote, so the placeholder is still inside the string.
+	template := `SELECT 'a\' ${name}' # ${name}` + "\n, ${name}"
+	got, args, err := BindCompile(template, map[string]string{"name": "x"}, nil, func(int) string { return "?" }, mysqlDialect)
+	if err == nil {
+		t.Errorf("BindCompile(%q) = %q, %v, want an error", template, got, args)
+	}
+
+	template = "SELECT ${name} # ${name}\n, ${name}"
+	got, args, err = BindCompile(template, map[string]string{"name": "x"}, nil, func(int) string { return "?" }, mysqlDialect)
+	if err != nil {
+		t.Fatalf("BindCompile(%q) failed: %v", template, err)
+	}
+	if want := "SELECT ? # ${name}\n, ?"; got != want {
+		t.Errorf("BindCompile(%q) = %q, want %q", template, got, want)
+	}
+	if len(args) != 2 {
+		t.Errorf("BindCompile(%q) args = %v, want 2 args", template, args)
+	}
+}
@@ -0,0 +1,227 @@
+package template
+
+import (
+	"data-explorer/pkg/dataexplorer/connection"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/samber/lo"
+)
+
+// Position locates a use of a param in a query template. Line and Column start at 1.
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// DiscoveredParam is a param a query template uses, with what is known about it.
+type DiscoveredParam struct {
+	Name      string     `json:"name"`
+	Positions []Position `json:"positions"`
+	// TypeHint is the declared type, or else a guess from the name and the SQL around the param.
+	TypeHint   ParamType `json:"type_hint"`
+	Declared   bool      `json:"declared"`
+	Required   bool      `json:"required"`
+	HasDefault bool      `json:"has_default"`
+	Default    *string   `json:"default,omitempty"`
+
+	AllowedValues []string `json:"allowed_values,omitempty"`
+}
+
+var (
+	quotedBefore  = regexp.MustCompile(`'$`)
+	inListBefore  = regexp.MustCompile(`(?i)\bin\s*\(\s*$`)
+	limitBefore   = regexp.MustCompile(`(?i)\b(limit|offset|top)\s+$`)
+	dateNames     = regexp.MustCompile(`(?i)(^|_)(date|day|dt)$`)
+	datetimeNames = regexp.MustCompile(`(?i)(_at|_time|timestamp|datetime)$`)
+	intNames      = regexp.MustCompile(`(?i)(^|_)(id|count|limit|offset|size|num|year|month)$`)
+	boolNames     = regexp.MustCompile(`(?i)^(is|has|include|enable)_`)
+	listNames     = regexp.MustCompile(`(?i)(^|_)(ids|list)$`)
+)
+
+// DiscoverParams lists the params used by tmpl, written for engine, in order of first use.
+// Its ${include:name} directives are expanded with lookup first, the params used by
+// snippets are listed as well but only their uses in tmpl have a position.
+func DiscoverParams(
+	tmpl string,
+	engine string,
+	declarations []ParamDeclaration,
+	lookup func(name string) (string, error),
+) ([]DiscoveredParam, error) {
+	expanded, spans, err := expandIncludeSpans(tmpl, lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	var uses []paramUse
+	if engine == EngineGo {
+		t, err := template.New("query").Funcs(Funcs(connection.Dialect{})).Parse(expanded)
+		if err != nil {
+			return nil, err
+		}
+		if t.Tree != nil {
+			uses = goUses(t.Tree.Root, nil)
+		}
+	} else {
+		for _, match := range re.FindAllStringSubmatchIndex(expanded, -1) {
+			if name := expanded[match[2]:match[3]]; !isQueryReference(name) {
+				uses = append(uses, paramUse{name: name, offset: match[0]})
+			}
+		}
+	}
+
+	var params []DiscoveredParam
+	for _, use := range uses {
+		_, index, found := lo.FindIndexOf(params, func(param DiscoveredParam) bool {
+			return param.Name == use.name
+		})
+		if !found {
+			params = append(params, DiscoveredParam{Name: use.name, Positions: []Position{}})
+			index = len(params) - 1
+		}
+		param := &params[index]
+		if offset, ok := originalOffset(spans, use.offset); ok {
+			param.Positions = append(param.Positions, position(tmpl, offset))
+		}
+		if param.TypeHint == "" {
+			param.TypeHint = use.hint
+			if param.TypeHint == "" {
+				param.TypeHint = contextHint(expanded[:use.offset])
+			}
+		}
+	}
+
+	for index := range params {
+		param := &params[index]
+		if declaration, ok := lo.Find(declarations, func(declaration ParamDeclaration) bool {
+			return declaration.Name == param.Name
+		}); ok {
+			param.Declared = true
+			if declaration.Type != "" {
+				param.TypeHint = declaration.Type
+			}
+			param.Required = declaration.Required
+			param.HasDefault = declaration.Default != nil
+			param.Default = declaration.Default
+			param.AllowedValues = declaration.AllowedValues
+			continue
+		}
+		// Undeclared params must be given, Go templates refuse to render missing ones.
+		param.Required = true
+		if param.TypeHint == "" {
+			param.TypeHint = nameHint(param.Name)
+		}
+	}
+
+	return params, nil
+}
+
+// originalOffset maps an offset of the expanded template back to the template, it
+// is not found when the offset lies in an included snippet.
+func originalOffset(spans []includeSpan, offset int) (int, bool) {
+	shift := 0
+	for _, span := range spans {
+		if offset < span.start {
+			break
+		}
+		if offset < span.end {
+			return 0, false
+		}
+		shift = span.shift
+	}
+	return offset - shift, true
+}
+
+type paramUse struct {
+	name   string
+	offset int
+	hint   ParamType
+}
+
+// goUses collects the top level fields used by the nodes. Inside range and with
+// the dot is something else than the params, so only their pipelines are looked at.
+func goUses(node parse.Node, uses []paramUse) []paramUse {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return uses
+		}
+		for _, child := range node.Nodes {
+			uses = goUses(child, uses)
+		}
+	case *parse.ActionNode:
+		uses = goUses(node.Pipe, uses)
+	case *parse.IfNode:
+		uses = goUses(node.Pipe, uses)
+		uses = goUses(node.List, uses)
+		uses = goUses(node.ElseList, uses)
+	case *parse.RangeNode:
+		start := len(uses)
+		uses = goUses(node.Pipe, uses)
+		for index := start; index < len(uses); index++ {
+			uses[index].hint = ParamList
+		}
+		uses = goUses(node.ElseList, uses)
+	case *parse.WithNode:
+		uses = goUses(node.Pipe, uses)
+		uses = goUses(node.ElseList, uses)
+	case *parse.PipeNode:
+		if node == nil {
+			return uses
+		}
+		for _, command := range node.Cmds {
+			uses = goUses(command, uses)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			uses = goUses(arg, uses)
+		}
+	case *parse.ChainNode:
+		uses = goUses(node.Node, uses)
+	case *parse.FieldNode:
+		uses = append(uses, paramUse{name: node.Ident[0], offset: int(node.Pos)})
+	}
+	return uses
+}
+
+func position(tmpl string, offset int) Position {
+	before := tmpl[:offset]
+	return Position{
+		Offset: offset,
+		Line:   strings.Count(before, "\n") + 1,
+		Column: offset - strings.LastIndex(before, "\n"),
+	}
+}
+
+// contextHint guesses the type of a param from the SQL right before it.
+func contextHint(before string) ParamType {
+	switch {
+	case quotedBefore.MatchString(before):
+		return ParamString
+	case inListBefore.MatchString(before):
+		return ParamList
+	case limitBefore.MatchString(before):
+		return ParamInt
+	}
+	return ""
+}
+
+// nameHint guesses the type of a param from its name, string when nothing matches.
+func nameHint(name string) ParamType {
+	switch {
+	case datetimeNames.MatchString(name):
+		return ParamDatetime
+	case dateNames.MatchString(name):
+		return ParamDate
+	case listNames.MatchString(name):
+		return ParamList
+	case boolNames.MatchString(name):
+		return ParamBool
+	case intNames.MatchString(name):
+		return ParamInt
+	}
+	return ParamString
+}
@@ -0,0 +1,122 @@
+package template
+
+import (
+	"data-explorer/pkg/dataexplorer/connection"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// EngineSimple substitutes ${name} placeholders, it is the default.
+	EngineSimple = "simple"
+	// EngineGo renders the query with text/template, see GoCompile.
+	EngineGo = "go"
+)
+
+// IsEngine tells whether engine names a template engine, empty meaning EngineSimple.
+func IsEngine(engine string) bool {
+	return lo.Contains([]string{"", EngineSimple, EngineGo}, engine)
+}
+
+// Funcs are the helpers available to EngineGo templates, quote and in_list write
+// string literals with the escaping rules of dialect.
+func Funcs(dialect connection.Dialect) template.FuncMap {
+	return template.FuncMap{
+		"quote": func(value interface{}) string {
+			return quote(value, dialect)
+		},
+		"join": join,
+		"in_list": func(values []string) string {
+			return inList(values, dialect)
+		},
+		"date_add": dateAdd,
+		"today":    today,
+		"ident":    ident,
+	}
+}
+
+// GoCompile renders tmpl with text/template for a database of dialect. Params are
+// available as {{.name}}, typed after their declaration: list params are []string
+// that can be ranged over, and empty optional params are empty strings so that they
+// render as nothing and {{if .name}} skips them. Using a param without a value is an error.
+func GoCompile(tmpl string, values map[string]string, declarations []ParamDeclaration, dialect connection.Dialect) (string, error) {
+	t, err := template.New("query").Funcs(Funcs(dialect)).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]interface{}{}
+	for name, value := range values {
+		declaration, _ := lo.Find(declarations, func(declaration ParamDeclaration) bool {
+			return declaration.Name == name
+		})
+		switch {
+		case declaration.Type == ParamList:
+			data[name] = SplitList(value)
+		case value == "":
+			data[name] = ""
+		default:
+			if data[name], err = declaration.arg(value); err != nil {
+				return "", fmt.Errorf("param %s %w", name, err)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// quote renders value as a SQL string literal of dialect, NULL for nil.
+func quote(value interface{}, dialect connection.Dialect) string {
+	if value == nil {
+		return "NULL"
+	}
+	literal := fmt.Sprint(value)
+	if dialect.BackslashEscapes {
+		literal = strings.ReplaceAll(literal, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(literal, "'", "''") + "'"
+}
+
+func join(values []string, separator string) string {
+	return strings.Join(values, separator)
+}
+
+// inList renders the items of a list as quoted literals separated by commas, for IN (...).
+func inList(values []string, dialect connection.Dialect) string {
+	if len(values) == 0 {
+		return "NULL"
+	}
+	return strings.Join(lo.Map(values, func(value string, index int) string {
+		return quote(value, dialect)
+	}), ", ")
+}
+
+// dateAdd adds days to a date or a datetime and returns it in the same layout.
+func dateAdd(value string, days int) (string, error) {
+	for _, layout := range []string{DateLayout, DatetimeLayout, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.AddDate(0, 0, days).Format(layout), nil
+		}
+	}
+	return "", fmt.Errorf("date_add: %q is not a date", value)
+}
+
+func today() string {
+	return time.Now().Format(DateLayout)
+}
+
+// ident returns name as it is once checked to look like an identifier.
+func ident(name string) (string, error) {
+	if !identifierValue.MatchString(name) {
+		return "", fmt.Errorf("ident: %q is not an identifier", name)
+	}
+	return name, nil
+}
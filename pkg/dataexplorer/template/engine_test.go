@@ -0,0 +1,145 @@
+package template
+
+import (
+	"data-explorer/pkg/dataexplorer/connection"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		dialect connection.Dialect
+		want    string
+	}{
+		{"plain", "abc", postgresDialect, "'abc'"},
+		{"quote", "it's", postgresDialect, "'it''s'"},
+		{"injection", "x'; DROP TABLE t; --", postgresDialect, "'x''; DROP TABLE t; --'"},
+		{"backslash", `a\`, postgresDialect, `'a\'`},
+		{"backslash quote", `\'; DROP TABLE t; --`, postgresDialect, `'\''; DROP TABLE t; --'`},
+		{"mysql quote", "it's", mysqlDialect, "'it''s'"},
+		{"mysql backslash", `a\`, mysqlDialect, `'a\\'`},
+		{"mysql backslash quote", `\'; DROP TABLE t; --`, mysqlDialect, `'\\''; DROP TABLE t; --'`},
+		{"number", int64(42), mysqlDialect, "'42'"},
+		{"nil", nil, mysqlDialect, "NULL"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := quote(test.value, test.dialect)
+			if got != test.want {
+				t.Errorf("quote(%q) = %s, want %s", test.value, got, test.want)
+			}
+			// The literal must lex as a single string in its dialect.
+			segments := connection.Segments(got, test.dialect)
+			if test.value != nil && (len(segments) != 1 || segments[0].Kind != connection.QuotedSegment) {
+				t.Errorf("quote(%q) = %s does not lex as a single string", test.value, got)
+			}
+		})
+	}
+}
+
+func TestInList(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		dialect connection.Dialect
+		want    string
+	}{
+		{"empty", nil, postgresDialect, "NULL"},
+		{"items", []string{"a", "b"}, postgresDialect, "'a', 'b'"},
+		{"quotes", []string{"it's", `a\`}, postgresDialect, `'it''s', 'a\'`},
+		{"mysql quotes", []string{"it's", `a\`}, mysqlDialect, `'it''s', 'a\\'`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := inList(test.values, test.dialect); got != test.want {
+				t.Errorf("inList(%q) = %s, want %s", test.values, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGoCompile(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     string
+		values       map[string]string
+		declarations []ParamDeclaration
+		dialect      connection.Dialect
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:     "quote",
+			template: "SELECT * FROM t WHERE name = {{quote .name}}",
+			values:   map[string]string{"name": `x\' OR 1=1 --`},
+			dialect:  mysqlDialect,
+			want:     `SELECT * FROM t WHERE name = 'x\\'' OR 1=1 --'`,
+		},
+		{
+			name:         "list",
+			template:     "SELECT * FROM t WHERE id IN ({{in_list .ids}}){{range .ids}} -- {{.}}{{end}}",
+			values:       map[string]string{"ids": "a, it's"},
+			declarations: []ParamDeclaration{{Name: "ids", Type: ParamList}},
+			dialect:      postgresDialect,
+			want:         "SELECT * FROM t WHERE id IN ('a', 'it''s') -- a -- it's",
+		},
+		{
+			name:         "optional",
+			template:     "SELECT 1{{if .since}} WHERE day >= {{quote .since}}{{end}}",
+			values:       map[string]string{"since": ""},
+			declarations: []ParamDeclaration{{Name: "since", Type: ParamDate}},
+			dialect:      postgresDialect,
+			want:         "SELECT 1",
+		},
+		{
+			name:         "typed",
+			template:     "SELECT {{.n}}{{if .b}}, true{{end}}",
+			values:       map[string]string{"n": "42", "b": "false"},
+			declarations: []ParamDeclaration{{Name: "n", Type: ParamInt}, {Name: "b", Type: ParamBool}},
+			dialect:      postgresDialect,
+			want:         "SELECT 42",
+		},
+		{
+			name:     "ident",
+			template: "SELECT * FROM {{ident .table}}",
+			values:   map[string]string{"table": "t; DROP TABLE t"},
+			dialect:  postgresDialect,
+			wantErr:  true,
+		},
+		{
+			name:     "missing key",
+			template: "SELECT * FROM t WHERE name = {{quote .name}}",
+			values:   map[string]string{},
+			dialect:  postgresDialect,
+			wantErr:  true,
+		},
+		{
+			name:     "missing key in if",
+			template: "SELECT 1{{if .name}} WHERE name = {{quote .name}}{{end}}",
+			values:   map[string]string{"other": "x"},
+			dialect:  postgresDialect,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := GoCompile(test.template, test.values, test.declarations, test.dialect)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("GoCompile(%q) = %q, want an error", test.template, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GoCompile(%q) failed: %v", test.template, err)
+			}
+			if got != test.want {
+				t.Errorf("GoCompile(%q) = %q, want %q", test.template, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+var (
+	includeRe   = regexp.MustCompile(`\${include:([\w.-]+)}`)
+	snippetName = regexp.MustCompile(`^[\w.-]+$`)
+)
+
+var ErrIncludeCycle = errors.New("include cycle")
+
+// IsSnippetName tells whether name can be used in ${include:name}.
+func IsSnippetName(name string) bool {
+	return snippetName.MatchString(name)
+}
+
+// ExpandIncludes replaces every ${include:name} of tmpl with the body returned by
+// lookup, expanding the includes of the body as well. A snippet that ends up
+// including itself is reported as ErrIncludeCycle.
+func ExpandIncludes(tmpl string, lookup func(name string) (string, error)) (string, error) {
+	return expandIncludes(tmpl, lookup, nil)
+}
+
+func expandIncludes(tmpl string, lookup func(name string) (string, error), stack []string) (string, error) {
+	var expandErr error
+	expanded := includeRe.ReplaceAllStringFunc(tmpl, func(s string) string {
+		if expandErr != nil {
+			return s
+		}
+
+		name := includeRe.FindStringSubmatch(s)[1]
+		path := append(append([]string{}, stack...), name)
+		if lo.Contains(stack, name) {
+			expandErr = fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(path, " -> "))
+			return s
+		}
+
+		body, err := lookup(name)
+		if err != nil {
+			expandErr = fmt.Errorf("include %s: %w", name, err)
+			return s
+		}
+
+		body, err = expandIncludes(body, lookup, path)
+		if err != nil {
+			expandErr = err
+			return s
+		}
+		return body
+	})
+
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// includeSpan is where the expansion of an include directive lies in the expanded template.
+type includeSpan struct {
+	start int
+	end   int
+	// shift is how much the expanded template is longer than the original one after the span.
+	shift int
+}
+
+// expandIncludeSpans works like ExpandIncludes and also returns where each top level
+// include ended up, so that offsets in the expanded template can be mapped back.
+func expandIncludeSpans(tmpl string, lookup func(name string) (string, error)) (string, []includeSpan, error) {
+	var sb strings.Builder
+	var spans []includeSpan
+	last := 0
+	for _, match := range includeRe.FindAllStringIndex(tmpl, -1) {
+		body, err := ExpandIncludes(tmpl[match[0]:match[1]], lookup)
+		if err != nil {
+			return "", nil, err
+		}
+		sb.WriteString(tmpl[last:match[0]])
+		start := sb.Len()
+		sb.WriteString(body)
+		spans = append(spans, includeSpan{start: start, end: sb.Len(), shift: sb.Len() - match[1]})
+		last = match[1]
+	}
+	sb.WriteString(tmpl[last:])
+	return sb.String(), spans, nil
+}
@@ -0,0 +1,200 @@
+package template
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+type ParamType string
+
+const (
+	ParamString   ParamType = "string"
+	ParamInt      ParamType = "int"
+	ParamFloat    ParamType = "float"
+	ParamDate     ParamType = "date"
+	ParamDatetime ParamType = "datetime"
+	ParamBool     ParamType = "bool"
+	ParamEnum     ParamType = "enum"
+	// ParamList values are comma separated, every item is checked against AllowedValues when set.
+	ParamList ParamType = "list"
+)
+
+const (
+	DateLayout     = "2006-01-02"
+	DatetimeLayout = "2006-01-02 15:04:05"
+)
+
+// ParamDeclaration describes a ${name} placeholder of a query.
+type ParamDeclaration struct {
+	Name          string    `json:"name"`
+	Type          ParamType `json:"type"`
+	Default       *string   `json:"default,omitempty"`
+	Required      bool      `json:"required"`
+	AllowedValues []string  `json:"allowed_values,omitempty"`
+	// Identifier params name a table or a column. Their value must look like an
+	// identifier and is substituted as it is, even when the other params are bound.
+	Identifier bool `json:"identifier,omitempty"`
+}
+
+type ParamError struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ParamsError lists every param that is missing or invalid.
+type ParamsError struct {
+	Errors []ParamError
+}
+
+func (e *ParamsError) Error() string {
+	return "invalid params: " + strings.Join(lo.Map(e.Errors, func(item ParamError, index int) string {
+		return item.Name + " " + item.Reason
+	}), ", ")
+}
+
+func (e *ParamsError) add(name string, reason string) {
+	e.Errors = append(e.Errors, ParamError{Name: name, Reason: reason})
+}
+
+func (e *ParamsError) orNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateDeclarations checks that the declarations themselves are usable.
+func ValidateDeclarations(declarations []ParamDeclaration) error {
+	paramsError := &ParamsError{}
+	seen := map[string]bool{}
+
+	for _, declaration := range declarations {
+		switch {
+		case declaration.Name == "":
+			paramsError.add(declaration.Name, "has no name")
+		case seen[declaration.Name]:
+			paramsError.add(declaration.Name, "is declared twice")
+		case !lo.Contains([]ParamType{ParamString, ParamInt, ParamFloat, ParamDate, ParamDatetime, ParamBool, ParamEnum, ParamList, ""}, declaration.Type):
+			paramsError.add(declaration.Name, fmt.Sprintf("has an unknown type %s", declaration.Type))
+		case declaration.Type == ParamEnum && len(declaration.AllowedValues) == 0:
+			paramsError.add(declaration.Name, "is an enum without allowed values")
+		case declaration.Identifier && !lo.Contains([]ParamType{ParamString, ParamEnum, ""}, declaration.Type):
+			paramsError.add(declaration.Name, "can only be an identifier as a string or an enum")
+		case declaration.Default != nil:
+			if _, err := declaration.parse(*declaration.Default); err != nil {
+				paramsError.add(declaration.Name, "has an invalid default: "+err.Error())
+			}
+		}
+		seen[declaration.Name] = true
+	}
+
+	return paramsError.orNil()
+}
+
+// ValidateParams checks values against the declarations and returns the values to
+// compile with: defaults filled in and bool and list values normalized. Values
+// without a declaration are passed through as they are.
+func ValidateParams(declarations []ParamDeclaration, values map[string]string) (map[string]string, error) {
+	if err := ValidateDeclarations(declarations); err != nil {
+		return nil, err
+	}
+
+	resolved := map[string]string{}
+	for key, value := range values {
+		resolved[key] = value
+	}
+
+	paramsError := &ParamsError{}
+	for _, declaration := range declarations {
+		value, ok := values[declaration.Name]
+		if !ok || value == "" {
+			if declaration.Default != nil {
+				value = *declaration.Default
+			} else if declaration.Required {
+				paramsError.add(declaration.Name, "is required")
+				continue
+			} else {
+				resolved[declaration.Name] = ""
+				continue
+			}
+		}
+
+		parsed, err := declaration.parse(value)
+		if err != nil {
+			paramsError.add(declaration.Name, err.Error())
+			continue
+		}
+		resolved[declaration.Name] = parsed
+	}
+
+	if err := paramsError.orNil(); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// parse validates a non-empty value and returns its normalized form.
+func (declaration *ParamDeclaration) parse(value string) (string, error) {
+	switch declaration.Type {
+	case ParamInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", fmt.Errorf("must be an integer, got %q", value)
+		}
+	case ParamFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("must be a number, got %q", value)
+		}
+	case ParamDate:
+		if _, err := time.Parse(DateLayout, value); err != nil {
+			return "", fmt.Errorf("must be a date like %s, got %q", DateLayout, value)
+		}
+	case ParamDatetime:
+		if _, err := time.Parse(DatetimeLayout, value); err != nil {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return "", fmt.Errorf("must be a datetime like %s or RFC 3339, got %q", DatetimeLayout, value)
+			}
+		}
+	case ParamBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("must be true or false, got %q", value)
+		}
+		return strconv.FormatBool(b), nil
+	case ParamList:
+		items := SplitList(value)
+		for _, item := range items {
+			if err := declaration.checkAllowed(item); err != nil {
+				return "", err
+			}
+		}
+		return strings.Join(items, ","), nil
+	}
+
+	if err := declaration.checkAllowed(value); err != nil {
+		return "", err
+	}
+	if declaration.Identifier && !identifierValue.MatchString(value) {
+		return "", fmt.Errorf("must be an identifier, got %q", value)
+	}
+	return value, nil
+}
+
+func (declaration *ParamDeclaration) checkAllowed(value string) error {
+	if len(declaration.AllowedValues) > 0 && !lo.Contains(declaration.AllowedValues, value) {
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(declaration.AllowedValues, ", "), value)
+	}
+	return nil
+}
+
+// SplitList splits a list param value into its trimmed, non-empty items.
+func SplitList(value string) []string {
+	return lo.Filter(lo.Map(strings.Split(value, ","), func(item string, index int) string {
+		return strings.TrimSpace(item)
+	}), func(item string, index int) bool {
+		return item != ""
+	})
+}
@@ -0,0 +1,106 @@
+package template
+
+import (
+	"data-explorer/pkg/dataexplorer/connection"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+var referenceRe = regexp.MustCompile(`\${query\.(\d+)\.column\.(\w+)}`)
+
+var numberLiteral = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][+-]?\d+)?$`)
+
+// QueryReference points at a column of the stored result of a saved query,
+// written ${query.<id>.column.<name>}.
+type QueryReference struct {
+	QueryID uint64
+	Column  string
+}
+
+// QueryReferences lists the references of tmpl in order, duplicates included.
+func QueryReferences(tmpl string) []QueryReference {
+	return lo.Map(referenceRe.FindAllStringSubmatch(tmpl, -1), func(match []string, index int) QueryReference {
+		queryId, _ := strconv.ParseUint(match[1], 10, 64)
+		return QueryReference{QueryID: queryId, Column: match[2]}
+	})
+}
+
+// ExpandQueryReferences replaces every reference of tmpl with the distinct values
+// returned by lookup, as a list of literals of dialect meant for IN (...). Strings
+// are quoted, numbers are not and an empty column becomes NULL. Other values, such
+// as binary columns, are an error.
+func ExpandQueryReferences(
+	tmpl string,
+	lookup func(reference QueryReference) ([]interface{}, error),
+	dialect connection.Dialect,
+) (string, error) {
+	var expandErr error
+	expanded := referenceRe.ReplaceAllStringFunc(tmpl, func(s string) string {
+		if expandErr != nil {
+			return s
+		}
+
+		reference := QueryReferences(s)[0]
+		values, err := lookup(reference)
+		if err != nil {
+			expandErr = err
+			return s
+		}
+		list, err := literalList(values, dialect)
+		if err != nil {
+			expandErr = fmt.Errorf("query %d column %s: %w", reference.QueryID, reference.Column, err)
+			return s
+		}
+		return list
+	})
+
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+func isQueryReference(name string) bool {
+	return referenceRe.MatchString("${" + name + "}")
+}
+
+func literalList(values []interface{}, dialect connection.Dialect) (string, error) {
+	var literals []string
+	for _, value := range values {
+		literal, err := literal(value, dialect)
+		if err != nil {
+			return "", err
+		}
+		literals = append(literals, literal)
+	}
+
+	literals = lo.Uniq(literals)
+	if len(literals) == 0 {
+		return "NULL", nil
+	}
+	return strings.Join(literals, ", "), nil
+}
+
+// literal renders a scalar of a stored result as a literal of dialect.
+func literal(value interface{}, dialect connection.Dialect) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quote(value, dialect), nil
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(value)), nil
+	case json.Number, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		number := fmt.Sprint(value)
+		if !numberLiteral.MatchString(number) {
+			return "", fmt.Errorf("%s cannot be used as a literal", number)
+		}
+		return number, nil
+	}
+	return "", fmt.Errorf("%T values cannot be used as literals", value)
+}
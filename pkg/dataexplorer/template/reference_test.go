@@ -0,0 +1,59 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestExpandQueryReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []interface{}
+		want    string
+		wantErr bool
+	}{
+		{"strings", []interface{}{"a", "it's", "a"}, "SELECT * FROM t WHERE id IN ('a', 'it''s')", false},
+		{"numbers", []interface{}{json.Number("12345678901234567890"), json.Number("-1.5e3"), 7, 2.5}, "SELECT * FROM t WHERE id IN (12345678901234567890, -1.5e3, 7, 2.5)", false},
+		{"bools and null", []interface{}{true, nil}, "SELECT * FROM t WHERE id IN (TRUE, NULL)", false},
+		{"empty", nil, "SELECT * FROM t WHERE id IN (NULL)", false},
+		{"binary", []interface{}{map[string]interface{}{"$binary": "AAE="}}, "", true},
+		{"array", []interface{}{[]interface{}{"a", "b"}}, "", true},
+		{"invalid number", []interface{}{json.Number("1); DROP TABLE t; --")}, "", true},
+		{"not a number", []interface{}{math.NaN()}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ExpandQueryReferences("SELECT * FROM t WHERE id IN (${query.1.column.id})", func(reference QueryReference) ([]interface{}, error) {
+				if reference != (QueryReference{QueryID: 1, Column: "id"}) {
+					t.Errorf("lookup(%+v), want query 1 column id", reference)
+				}
+				return test.values, nil
+			}, postgresDialect)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ExpandQueryReferences(%v) = %q, want an error", test.values, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandQueryReferences(%v) failed: %v", test.values, err)
+			}
+			if got != test.want {
+				t.Errorf("ExpandQueryReferences(%v) = %q, want %q", test.values, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandQueryReferencesLookupError(t *testing.T) {
+	lookupErr := errors.New("query 2 has no result, run it first")
+	_, err := ExpandQueryReferences("SELECT ${query.2.column.id}", func(reference QueryReference) ([]interface{}, error) {
+		return nil, lookupErr
+	}, postgresDialect)
+	if !errors.Is(err, lookupErr) {
+		t.Errorf("ExpandQueryReferences() = %v, want %v", err, lookupErr)
+	}
+}
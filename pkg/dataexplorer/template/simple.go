@@ -3,6 +3,8 @@ package template
 import (
 	"fmt"
 	"regexp"
+
+	"github.com/samber/lo"
 )
 
 var re = regexp.MustCompile(`\${([\w.]+)}`)
@@ -18,3 +20,10 @@ func SimpleCompile(template string, vars map[string]string) string {
 	})
 	return compiled
 }
+
+// Placeholders returns the distinct names of the ${name} placeholders in template, in order.
+func Placeholders(template string) []string {
+	return lo.Uniq(lo.Map(re.FindAllStringSubmatch(template, -1), func(match []string, index int) string {
+		return match[1]
+	}))
+}